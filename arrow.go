@@ -0,0 +1,144 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+)
+
+// batchRows is the number of rows written per Arrow record batch.
+const batchRows = 1024
+
+func init() {
+	rowFormats["arrow"] = &rowFormat{
+		mediaType:  "application/vnd.apache.arrow.stream",
+		extension:  "arrows",
+		newEncoder: newArrowEncoder,
+	}
+}
+
+// arrowType returns the Arrow type a column kind is exported as. Decimals
+// are exported as strings to keep their exact value.
+func arrowType(kind string) arrow.DataType {
+	switch kind {
+	case "int":
+		return arrow.PrimitiveTypes.Int64
+	case "float":
+		return arrow.PrimitiveTypes.Float64
+	case "bool":
+		return arrow.FixedWidthTypes.Boolean
+	case "binary":
+		return arrow.BinaryTypes.Binary
+	case "time":
+		return arrow.FixedWidthTypes.Timestamp_us
+	}
+	return arrow.BinaryTypes.String
+}
+
+// arrowEncoder writes an Arrow IPC stream, a record batch for every
+// batchRows rows.
+type arrowEncoder struct {
+	w       io.Writer
+	kinds   []string
+	builder *array.RecordBuilder
+	writer  *ipc.Writer
+	rows    int
+}
+
+func newArrowEncoder(w io.Writer, r *http.Request) rowEncoder {
+	return &arrowEncoder{w: w}
+}
+
+func (e *arrowEncoder) Begin(columns []*sql.ColumnType) error {
+	e.kinds = columnKindsOf(columns)
+	fields := make([]arrow.Field, len(columns))
+	for i, ct := range columns {
+		fields[i] = arrow.Field{Name: ct.Name(), Type: arrowType(e.kinds[i]), Nullable: true}
+	}
+	schema := arrow.NewSchema(fields, nil)
+
+	e.builder = array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	e.writer = ipc.NewWriter(e.w, ipc.WithSchema(schema))
+	return nil
+}
+
+func (e *arrowEncoder) Row(values []interface{}) error {
+	for i, v := range values {
+		if err := e.append(e.builder.Field(i), e.kinds[i], v); err != nil {
+			return fmt.Errorf("column %s: %s", e.builder.Schema().Field(i).Name, err)
+		}
+	}
+	e.rows++
+	if e.rows == batchRows {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *arrowEncoder) append(b array.Builder, kind string, v interface{}) error {
+	if v == nil {
+		b.AppendNull()
+		return nil
+	}
+
+	switch kind {
+	case "int":
+		i, err := toInt64(v)
+		if err != nil {
+			return err
+		}
+		b.(*array.Int64Builder).Append(i)
+	case "float":
+		f, err := toFloat64(v)
+		if err != nil {
+			return err
+		}
+		b.(*array.Float64Builder).Append(f)
+	case "bool":
+		t, err := toBool(v)
+		if err != nil {
+			return err
+		}
+		b.(*array.BooleanBuilder).Append(t)
+	case "binary":
+		bytes, ok := v.([]byte)
+		if !ok {
+			bytes = []byte(fmt.Sprint(v))
+		}
+		b.(*array.BinaryBuilder).Append(bytes)
+	case "time":
+		t, err := toTime(v)
+		if err != nil {
+			return err
+		}
+		b.(*array.TimestampBuilder).Append(arrow.Timestamp(t.UnixNano() / int64(time.Microsecond)))
+	default:
+		b.(*array.StringBuilder).Append(csvValue(v))
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a record batch.
+func (e *arrowEncoder) flush() error {
+	rec := e.builder.NewRecord()
+	defer rec.Release()
+	e.rows = 0
+	return e.writer.Write(rec)
+}
+
+func (e *arrowEncoder) End() error {
+	defer e.builder.Release()
+	if e.rows > 0 {
+		if err := e.flush(); err != nil {
+			return err
+		}
+	}
+	return e.writer.Close()
+}
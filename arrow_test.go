@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArrow(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+	db.MustExec("CREATE TABLE typed(i INTEGER, r REAL, d DECIMAL(10,2), b BLOB, t DATETIME, s TEXT)")
+	db.MustExec("INSERT INTO typed VALUES (7, 1.25, 10.5, x'00ff', '2020-01-02 03:04:05', 'hi')")
+	db.MustExec("INSERT INTO typed VALUES (NULL, NULL, NULL, NULL, NULL, NULL)")
+
+	req, _ := http.NewRequest("GET", "http://example.com/typed", nil)
+	req.Header.Set("Accept", "application/vnd.apache.arrow.stream")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	assert.Equal(w.Header().Get("Content-Disposition"), "attachment; filename=typed.arrows")
+
+	reader, err := ipc.NewReader(w.Body)
+	assert.Nil(err)
+	defer reader.Release()
+
+	schema := reader.Schema()
+	assert.Equal(schema.Field(0).Type, arrow.PrimitiveTypes.Int64)
+	assert.Equal(schema.Field(1).Type, arrow.PrimitiveTypes.Float64)
+	assert.Equal(schema.Field(2).Type, arrow.BinaryTypes.String)
+	assert.Equal(schema.Field(3).Type, arrow.BinaryTypes.Binary)
+	assert.Equal(schema.Field(4).Type.ID(), arrow.TIMESTAMP)
+	assert.Equal(schema.Field(5).Type, arrow.BinaryTypes.String)
+
+	assert.True(reader.Next())
+	rec := reader.Record()
+	assert.Equal(rec.NumRows(), int64(2))
+	assert.Equal(rec.Column(0).(*array.Int64).Value(0), int64(7))
+	assert.Equal(rec.Column(1).(*array.Float64).Value(0), 1.25)
+	assert.Equal(rec.Column(2).(*array.String).Value(0), "10.5")
+	assert.Equal(rec.Column(3).(*array.Binary).Value(0), []byte{0, 255})
+	assert.Equal(rec.Column(4).(*array.Timestamp).Value(0), arrow.Timestamp(1577934245000000))
+	assert.Equal(rec.Column(5).(*array.String).Value(0), "hi")
+	for i := 0; i < 6; i++ {
+		assert.True(rec.Column(i).IsNull(1))
+	}
+	assert.False(reader.Next())
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gorilla/websocket"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	// changesRoute is the url path, relative to the url prefix, that serves
+	// change subscriptions
+	changesRoute = "_changes"
+
+	// subscriptionBuffer is how many changes a subscriber may fall behind
+	// by before it is disconnected
+	subscriptionBuffer = 256
+
+	// pingInterval is how often idle WebSocket connections are pinged
+	pingInterval = 30 * time.Second
+)
+
+var (
+	changes = newChangeFeed()
+
+	pendingMu sync.Mutex
+	pending   = make(map[sqlx.Execer][]*change)
+
+	upgrader = websocket.Upgrader{}
+)
+
+// change is an insert, update, or delete of a row. Old holds the row
+// before an update when it is known.
+type change struct {
+	Seq   uint64                 `json:"seq"`
+	Table string                 `json:"table"`
+	Op    string                 `json:"op"`
+	Row   map[string]interface{} `json:"row"`
+	Old   map[string]interface{} `json:"old,omitempty"`
+}
+
+// changeFeed numbers changes as they are published, keeps the most recent
+// ones for subscribers resuming from a sequence number, and sends them to
+// subscribers. Sequence numbers start from the time the feed was created,
+// in microseconds, so they keep increasing when sqld restarts.
+type changeFeed struct {
+	sync.Mutex
+	seq uint64
+	// floor is the highest sequence number no longer kept
+	floor  uint64
+	recent []*change
+	subs   map[*subscription]bool
+}
+
+func newChangeFeed() *changeFeed {
+	start := uint64(time.Now().UnixNano() / int64(time.Microsecond))
+	return &changeFeed{seq: start, floor: start, subs: make(map[*subscription]bool)}
+}
+
+// subscription receives the changes to a table whose rows match filter.
+// events is closed if the subscriber falls too far behind. start is the
+// sequence number of the last change before the subscription began.
+type subscription struct {
+	table  string
+	filter map[string][]string
+	events chan *change
+	start  uint64
+}
+
+// matches reports whether a change is to a row the subscription wants.
+// An update matches when the row matches before or after it.
+func (s *subscription) matches(c *change) bool {
+	return c.Table == s.table && (rowMatches(c.Row, s.filter) || c.Old != nil && rowMatches(c.Old, s.filter))
+}
+
+// rowMatches reports whether every filtered column of row holds one of
+// the filter's values, compared the way they are written in a query
+// string.
+func rowMatches(row map[string]interface{}, filter map[string][]string) bool {
+	for col, values := range filter {
+		v := csvValue(row[col])
+		found := false
+		for _, want := range values {
+			if v == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// publish numbers changes and sends them to their subscribers.
+// Subscribers that cannot keep up are dropped.
+func (f *changeFeed) publish(cs []*change) {
+	f.Lock()
+	defer f.Unlock()
+
+	for _, c := range cs {
+		f.seq++
+		c.Seq = f.seq
+		f.recent = append(f.recent, c)
+
+		for s := range f.subs {
+			if !s.matches(c) {
+				continue
+			}
+			select {
+			case s.events <- c:
+			default:
+				delete(f.subs, s)
+				close(s.events)
+			}
+		}
+	}
+
+	if over := len(f.recent) - *changeLogSize; over > 0 {
+		f.floor = f.recent[over-1].Seq
+		f.recent = append(f.recent[:0:0], f.recent[over:]...)
+	}
+}
+
+// subscribe starts a subscription. When since is given, the changes after
+// it that are still kept are returned to be sent first.
+func (f *changeFeed) subscribe(table string, filter map[string][]string, since *uint64) (*subscription, []*change, *SqldError) {
+	f.Lock()
+	defer f.Unlock()
+
+	s := &subscription{table: table, filter: filter, events: make(chan *change, subscriptionBuffer), start: f.seq}
+	var backlog []*change
+	if since != nil {
+		if *since < f.floor || *since > f.seq {
+			return nil, nil, NewError(fmt.Errorf("changes since %d are no longer available", *since), http.StatusGone)
+		}
+		for _, c := range f.recent {
+			if c.Seq > *since && s.matches(c) {
+				backlog = append(backlog, c)
+			}
+		}
+	}
+	f.subs[s] = true
+	return s, backlog, nil
+}
+
+// unsubscribe ends a subscription.
+func (f *changeFeed) unsubscribe(s *subscription) {
+	f.Lock()
+	defer f.Unlock()
+	if f.subs[s] {
+		delete(f.subs, s)
+		close(s.events)
+	}
+}
+
+// recordingChanges reports whether sqld publishes the changes made by its
+// own writes. With a notify channel every change comes from Postgres.
+func recordingChanges() bool {
+	return *changeLogSize > 0 && *notifyChannel == ""
+}
+
+// recordChange publishes a change made with e, or holds it until the
+// transaction e belongs to commits.
+func recordChange(e sqlx.Execer, c *change) {
+	if !recordingChanges() {
+		return
+	}
+	if e == db {
+		changes.publish([]*change{c})
+		return
+	}
+	pendingMu.Lock()
+	pending[e] = append(pending[e], c)
+	pendingMu.Unlock()
+}
+
+// finishChanges publishes the changes held for a transaction when it
+// commits, and forgets them when it rolls back.
+func finishChanges(tx *sqlx.Tx, commit bool) {
+	pendingMu.Lock()
+	cs := pending[tx]
+	delete(pending, tx)
+	pendingMu.Unlock()
+
+	if commit && len(cs) > 0 {
+		changes.publish(cs)
+	}
+}
+
+// changeMark returns how many changes are held for the transaction e
+// belongs to, to pass to discardChanges.
+func changeMark(e sqlx.Execer) int {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	return len(pending[e])
+}
+
+// discardChanges forgets the changes held for a transaction since mark,
+// when it rolls back to a savepoint.
+func discardChanges(e sqlx.Execer, mark int) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	if len(pending[e]) > mark {
+		pending[e] = pending[e][:mark]
+	}
+}
+
+// rowWrite describes an update or delete so the rows it changes can be
+// published. query selects the rows it is about to change, and values are
+// the new values set on them.
+type rowWrite struct {
+	table  string
+	op     string
+	query  string
+	args   []interface{}
+	values map[string]interface{}
+}
+
+// requestWrite describes the update or delete of a request, which targets
+// the rows a GET of the same url would read.
+func requestWrite(r *http.Request, values map[string]interface{}) *rowWrite {
+	if !recordingChanges() {
+		return nil
+	}
+	table, _, _ := parseRequest(r)
+	query, args, err := buildSelectQuery(r)
+	if err != nil {
+		return nil
+	}
+	op := "update"
+	if r.Method == "DELETE" {
+		op = "delete"
+	}
+	return &rowWrite{table: table, op: op, query: query, args: args, values: values}
+}
+
+// selectWrite describes a write that targets the rows target selects.
+func selectWrite(table, op string, target squirrel.SelectBuilder, values map[string]interface{}) *rowWrite {
+	if !recordingChanges() {
+		return nil
+	}
+	query, args, err := target.ToSql()
+	if err != nil {
+		return nil
+	}
+	return &rowWrite{table: table, op: op, query: query, args: args, values: values}
+}
+
+// before reads the rows the write is about to change.
+func (w *rowWrite) before(ext sqlx.Ext) ([]map[string]interface{}, error) {
+	if w == nil || !recordingChanges() {
+		return nil, nil
+	}
+	return readQuery(ext, w.query, w.args)
+}
+
+// after records a change for each row read by before. Updated rows are
+// published with the written values applied.
+func (w *rowWrite) after(ext sqlx.Ext, rows []map[string]interface{}) {
+	for _, old := range rows {
+		c := &change{Table: w.table, Op: w.op, Row: old}
+		if w.op != "delete" {
+			c.Row = make(map[string]interface{}, len(old))
+			for col, v := range old {
+				c.Row[col] = v
+			}
+			for col, v := range w.values {
+				c.Row[col] = v
+			}
+			if _, ok := w.values[*versionColumn]; !ok && versioned(w.table) {
+				if n, err := toInt64(old[*versionColumn]); err == nil {
+					c.Row[*versionColumn] = n + 1
+				}
+			}
+		}
+		if w.op == "update" {
+			c.Old = old
+		}
+		recordChange(ext, c)
+	}
+}
+
+// notifyChange decodes the payload of a notification sent on the notify
+// channel, a JSON object with the table, op, row, and optionally the old
+// row of a change.
+func notifyChange(payload string) (*change, error) {
+	decoder := json.NewDecoder(strings.NewReader(payload))
+	decoder.UseNumber()
+	var c change
+	if err := decoder.Decode(&c); err != nil {
+		return nil, err
+	}
+	c.Op = strings.ToLower(c.Op)
+	if c.Table == "" || c.Row == nil {
+		return nil, errors.New("notification needs a table and row")
+	}
+	if c.Op != "insert" && c.Op != "update" && c.Op != "delete" {
+		return nil, fmt.Errorf("unknown op %q", c.Op)
+	}
+	return &c, nil
+}
+
+// listenChanges publishes the changes Postgres triggers send on the
+// notify channel.
+func listenChanges(dsn, channel string) error {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Listening for changes on %s: %s\n", channel, err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		for n := range listener.Notify {
+			if n == nil {
+				// The connection was re-established, notifications sent
+				// while it was down are lost
+				continue
+			}
+			c, err := notifyChange(n.Extra)
+			if err != nil {
+				log.Printf("Ignoring notification on %s: %s\n", channel, err)
+				continue
+			}
+			changes.publish([]*change{c})
+		}
+	}()
+	return nil
+}
+
+// changeStream is a subscription that handleQuery serves once the request
+// has been handled.
+type changeStream struct {
+	sub     *subscription
+	backlog []*change
+}
+
+// handleChanges handles requests to the changes route:
+//
+//	GET {url}_changes/:table?column=value&__since__=seq
+//
+// subscribes to the changes to the matching rows of the table over a
+// WebSocket.
+func handleChanges(r *http.Request) (interface{}, *SqldError) {
+	if *changeLogSize <= 0 {
+		return nil, NotFound(errors.New("change subscriptions are disabled, start sqld with -changes"))
+	}
+	if r.Method != "GET" {
+		return nil, NewError(nil, http.StatusMethodNotAllowed)
+	}
+
+	paths := routePaths(r)
+	if len(paths) != 2 || paths[1] == "" {
+		return nil, NotFound(nil)
+	}
+	table := paths[1]
+
+	if !websocket.IsWebSocketUpgrade(r) {
+		return nil, BadRequest(errors.New("subscribe to changes with a WebSocket"))
+	}
+
+	filter, since, err := changeFilter(r, table)
+	if err != nil {
+		return nil, err
+	}
+	sub, backlog, err := changes.subscribe(table, filter, since)
+	if err != nil {
+		return nil, err
+	}
+	return &changeStream{sub: sub, backlog: backlog}, nil
+}
+
+// changeFilter reads the filter and resume point of a subscription from
+// the request's query string.
+func changeFilter(r *http.Request, table string) (map[string][]string, *uint64, *SqldError) {
+	schema, err := loadTableSchema(table)
+	if err != nil {
+		return nil, nil, BadRequest(err)
+	}
+
+	filter := make(map[string][]string)
+	var since *uint64
+	for key, values := range r.URL.Query() {
+		switch {
+		case key == "__since__":
+			seq, err := strconv.ParseUint(values[0], 10, 64)
+			if err != nil {
+				return nil, nil, BadRequest(fmt.Errorf("invalid __since__ %q", values[0]))
+			}
+			since = &seq
+		case strings.HasPrefix(key, "__"):
+		default:
+			if _, ok := schema.kinds[key]; !ok {
+				return nil, nil, BadRequest(fmt.Errorf("unknown column %s", key))
+			}
+			filter[key] = values
+		}
+	}
+	return filter, since, nil
+}
+
+// serveWebSocket sends the changes of a subscription over a WebSocket, one
+// JSON message per change, until the client goes away. Subscribers that
+// fall behind are disconnected and can resume from the last sequence
+// number they received.
+func (s *changeStream) serveWebSocket(w http.ResponseWriter, r *http.Request) error {
+	defer changes.unsubscribe(s.sub)
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(c *change) error {
+		b, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		conn.SetWriteDeadline(time.Now().Add(pingInterval))
+		return conn.WriteMessage(websocket.TextMessage, b)
+	}
+	for _, c := range s.backlog {
+		if err := send(c); err != nil {
+			return err
+		}
+	}
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case c, ok := <-s.sub.events:
+			if !ok {
+				msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscriber fell behind, resume with __since__")
+				return conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			}
+			if err := send(c); err != nil {
+				return err
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingInterval)); err != nil {
+				return err
+			}
+		case <-done:
+			return nil
+		}
+	}
+}
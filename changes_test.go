@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mmaelzer/sqld/sqldpb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestChangeFeed(t *testing.T) {
+	assert := assert.New(t)
+	*changeLogSize = 2
+	defer func() { *changeLogSize = 0 }()
+
+	feed := newChangeFeed()
+	start := feed.seq
+	sub, _, err := feed.subscribe("t1", map[string][]string{"a": {"hi"}}, nil)
+	assert.Nil(err)
+
+	feed.publish([]*change{
+		{Table: "t1", Op: "insert", Row: map[string]interface{}{"a": "hi"}},
+		{Table: "t1", Op: "insert", Row: map[string]interface{}{"a": "how"}},
+		{Table: "t2", Op: "insert", Row: map[string]interface{}{"a": "hi"}},
+		{Table: "t1", Op: "update", Row: map[string]interface{}{"a": "moved"}, Old: map[string]interface{}{"a": "hi"}},
+	})
+	c := <-sub.events
+	assert.Equal(c.Seq, start+1)
+	c = <-sub.events
+	assert.Equal(c.Op, "update")
+	assert.Equal(len(sub.events), 0)
+
+	since := start + 2
+	_, backlog, err := feed.subscribe("t1", nil, &since)
+	assert.Nil(err)
+	assert.Len(backlog, 1)
+	assert.Equal(backlog[0].Seq, start+4)
+
+	since = start + 1
+	_, _, err = feed.subscribe("t1", nil, &since)
+	assert.Equal(err.Code, http.StatusGone)
+
+	since = start + 5
+	_, _, err = feed.subscribe("t1", nil, &since)
+	assert.Equal(err.Code, http.StatusGone)
+
+	feed.unsubscribe(sub)
+	_, open := <-sub.events
+	assert.False(open)
+}
+
+func TestNotifyChange(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := notifyChange(`{"table":"orders","op":"UPDATE","row":{"id":12345678901234567890,"status":"new"},"old":{"status":"open"}}`)
+	assert.Nil(err)
+	assert.Equal(c.Op, "update")
+	assert.True(rowMatches(c.Row, map[string][]string{"id": {"12345678901234567890"}}))
+	assert.Equal(c.Old["status"], "open")
+
+	_, err = notifyChange(`{"table":"orders","op":"truncate","row":{}}`)
+	assert.NotNil(err)
+
+	_, err = notifyChange(`{"op":"insert","row":{}}`)
+	assert.NotNil(err)
+}
+
+func readChange(assert *assert.Assertions, conn *websocket.Conn) map[string]interface{} {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, b, err := conn.ReadMessage()
+	assert.Nil(err)
+	var c map[string]interface{}
+	json.Unmarshal(b, &c)
+	return c
+}
+
+func TestChangeSubscription(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+	*changeLogSize = 100
+	defer func() { *changeLogSize = 0 }()
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/_changes/t1"
+
+	req, _ := http.NewRequest("GET", server.URL+"/_changes/t1", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusBadRequest)
+
+	_, res, err := websocket.DefaultDialer.Dial(wsURL+"?nope=1", nil)
+	assert.NotNil(err)
+	assert.Equal(res.StatusCode, http.StatusBadRequest)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?a=hi&a=new", nil)
+	assert.Nil(err)
+	defer conn.Close()
+
+	write := func(method, path, body string) int {
+		req, _ := http.NewRequest(method, "http://example.com"+path, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(write("POST", "/t1", `{"a":"other","b":"x"}`), http.StatusCreated)
+	assert.Equal(write("POST", "/t1", `{"a":"new","b":"y"}`), http.StatusCreated)
+	c := readChange(assert, conn)
+	assert.Equal(c["op"], "insert")
+	assert.Equal(c["row"], map[string]interface{}{"a": "new", "b": "y", "id": float64(4)})
+	first := uint64(c["seq"].(float64))
+
+	assert.Equal(write("PUT", "/t1?a=hi", `{"b":"bye"}`), http.StatusNoContent)
+	c = readChange(assert, conn)
+	assert.Equal(c["op"], "update")
+	assert.Equal(c["row"], map[string]interface{}{"a": "hi", "b": "bye"})
+	assert.Equal(c["old"], map[string]interface{}{"a": "hi", "b": "there"})
+
+	// changes in a transaction are only sent once it commits
+	req, _ = http.NewRequest("POST", "http://example.com/_tx", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var tx map[string]string
+	json.Unmarshal(w.Body.Bytes(), &tx)
+	req, _ = http.NewRequest("DELETE", "http://example.com/t1?a=new", nil)
+	req.Header.Set(txHeader, tx["id"])
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusNoContent)
+	assert.Equal(write("POST", "/_tx/"+tx["id"]+"/rollback", ""), http.StatusNoContent)
+
+	assert.Equal(write("DELETE", "/t1?a=new", ""), http.StatusNoContent)
+	c = readChange(assert, conn)
+	assert.Equal(c["op"], "delete")
+	assert.Equal(c["row"], map[string]interface{}{"a": "new", "b": "y"})
+	assert.Equal(uint64(c["seq"].(float64)), first+2)
+
+	resumed, _, err := websocket.DefaultDialer.Dial(wsURL+"?a=hi&a=new&__since__="+strconv.FormatUint(first, 10), nil)
+	assert.Nil(err)
+	defer resumed.Close()
+	assert.Equal(readChange(assert, resumed)["op"], "update")
+	assert.Equal(readChange(assert, resumed)["op"], "delete")
+
+	_, res, err = websocket.DefaultDialer.Dial(wsURL+"?__since__=1", nil)
+	assert.NotNil(err)
+	assert.Equal(res.StatusCode, http.StatusGone)
+}
+
+func TestChangeSources(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+	*changeLogSize = 100
+	*allowRaw = true
+	defer func() { *changeLogSize, *allowRaw = 0, false }()
+
+	sub, _, sqldErr := changes.subscribe("t1", nil, nil)
+	assert.Nil(sqldErr)
+	defer changes.unsubscribe(sub)
+
+	// Raw writes are not published, GraphQL and gRPC writes are
+	req, _ := http.NewRequest("POST", "http://example.com/", bytes.NewBufferString(`{"write": "DELETE FROM t1 WHERE a = 'hi'"}`))
+	_, sqldErr = raw(req)
+	assert.Nil(sqldErr)
+
+	_, res := postGraphQL(handler, `mutation { update_t1(a: "how", set: {b: "graphql"}) }`)
+	assert.Empty(res.Errors)
+	c := <-sub.events
+	assert.Equal(c.Op, "update")
+	assert.Equal(c.Row["b"], "graphql")
+
+	_, err := (&sqldServer{}).Delete(context.Background(), &sqldpb.DeleteRequest{
+		Table:   "t1",
+		Filters: map[string]*structpb.Value{"a": structpb.NewStringValue("how")},
+	})
+	assert.Nil(err)
+	c = <-sub.events
+	assert.Equal(c.Op, "delete")
+	assert.Equal(c.Row["a"], "how")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// codec encodes responses and decodes request bodies in a media type.
+type codec struct {
+	mediaType string
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+}
+
+// jsonCodec is the codec used when a request names no other.
+var jsonCodec = &codec{
+	mediaType: "application/json",
+	marshal: func(v interface{}) ([]byte, error) {
+		var buf bytes.Buffer
+		err := json.NewEncoder(&buf).Encode(v)
+		return buf.Bytes(), err
+	},
+	unmarshal: json.Unmarshal,
+}
+
+// cborDecMode decodes CBOR maps into map[string]interface{} like the JSON
+// and MessagePack codecs, rather than map[interface{}]interface{}.
+var cborDecMode, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+}.DecMode()
+
+// codecs are the available codecs in order of preference. Add a codec here
+// to accept it in request bodies and offer it for responses.
+var codecs = []*codec{
+	jsonCodec,
+	{
+		mediaType: "application/msgpack",
+		marshal: func(v interface{}) ([]byte, error) {
+			var buf bytes.Buffer
+			err := msgpack.NewEncoder(&buf).UseJSONTag(true).Encode(plainValue(v))
+			return buf.Bytes(), err
+		},
+		unmarshal: func(data []byte, v interface{}) error {
+			return msgpack.NewDecoder(bytes.NewReader(data)).
+				UseJSONTag(true).
+				UseDecodeInterfaceLoose(true).
+				Decode(v)
+		},
+	},
+	{
+		mediaType: "application/cbor",
+		marshal: func(v interface{}) ([]byte, error) {
+			return cbor.Marshal(plainValue(v))
+		},
+		unmarshal: cborDecMode.Unmarshal,
+	},
+}
+
+// codecAliases maps other names clients use for a media type to the name
+// it is registered under.
+var codecAliases = map[string]string{
+	"application/x-msgpack":   "application/msgpack",
+	"application/vnd.msgpack": "application/msgpack",
+}
+
+// findCodec returns the codec for a media type, or nil.
+func findCodec(mediaType string) *codec {
+	if alias, ok := codecAliases[mediaType]; ok {
+		mediaType = alias
+	}
+	for _, c := range codecs {
+		if c.mediaType == mediaType {
+			return c
+		}
+	}
+	return nil
+}
+
+// requestCodec returns the codec for a request body named by its
+// Content-Type header. Bodies without one are JSON.
+func requestCodec(r *http.Request) (*codec, *SqldError) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return jsonCodec, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+	if c := findCodec(mediaType); c != nil {
+		return c, nil
+	}
+	return nil, NewError(errors.New("unsupported Content-Type "+mediaType), http.StatusUnsupportedMediaType)
+}
+
+// decodeBody decodes a request body with the codec named by the request's
+// Content-Type.
+func decodeBody(r *http.Request, body []byte, v interface{}) *SqldError {
+	c, sqldErr := requestCodec(r)
+	if sqldErr != nil {
+		return sqldErr
+	}
+	if err := c.unmarshal(body, v); err != nil {
+		return BadRequest(err)
+	}
+	return nil
+}
+
+// responseCodec returns the codec the request's Accept header prefers,
+// falling back to JSON.
+func responseCodec(r *http.Request) *codec {
+	offers := make([]string, 0, len(codecs)+len(codecAliases))
+	for _, c := range codecs {
+		offers = append(offers, c.mediaType)
+	}
+	for alias := range codecAliases {
+		offers = append(offers, alias)
+	}
+	if c := findCodec(negotiate(r, offers)); c != nil {
+		return c
+	}
+	return jsonCodec
+}
+
+// plainValue decodes the stored JSON held by json.RawMessage values, such
+// as replayed responses and query plans, so that codecs other than JSON
+// encode the data rather than its JSON text.
+func plainValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.RawMessage:
+		var decoded interface{}
+		if err := json.Unmarshal(val, &decoded); err != nil {
+			return string(val)
+		}
+		return decoded
+	case map[string]interface{}:
+		plain := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			plain[k] = plainValue(item)
+		}
+		return plain
+	}
+	return v
+}
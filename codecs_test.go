@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+func TestResponseCodec(t *testing.T) {
+	assert := assert.New(t)
+
+	req, _ := http.NewRequest("GET", "http://example.com/t1", nil)
+	assert.Equal(responseCodec(req), jsonCodec)
+
+	req.Header.Set("Accept", "application/cbor")
+	assert.Equal(responseCodec(req).mediaType, "application/cbor")
+
+	req.Header.Set("Accept", "application/x-msgpack")
+	assert.Equal(responseCodec(req).mediaType, "application/msgpack")
+
+	req.Header.Set("Accept", "image/png")
+	assert.Equal(responseCodec(req), jsonCodec)
+}
+
+func TestRequestCodec(t *testing.T) {
+	assert := assert.New(t)
+
+	req, _ := http.NewRequest("POST", "http://example.com/t1", nil)
+	c, err := requestCodec(req)
+	assert.Nil(err)
+	assert.Equal(c, jsonCodec)
+
+	req.Header.Set("Content-Type", "application/msgpack")
+	c, err = requestCodec(req)
+	assert.Nil(err)
+	assert.Equal(c.mediaType, "application/msgpack")
+
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	_, err = requestCodec(req)
+	assert.Equal(err.Code, http.StatusUnsupportedMediaType)
+}
+
+func TestPlainValue(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(plainValue(json.RawMessage(`{"a":1}`)), map[string]interface{}{"a": float64(1)})
+	assert.Equal(plainValue(map[string]interface{}{
+		"sql":  "SELECT 1",
+		"plan": json.RawMessage(`[1]`),
+	}), map[string]interface{}{
+		"sql":  "SELECT 1",
+		"plan": []interface{}{float64(1)},
+	})
+	assert.Equal(plainValue("hi"), "hi")
+}
+
+func TestCodecs(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+
+	body, _ := msgpack.Marshal(map[string]interface{}{"a": "yo", "b": "mp"})
+	req, _ := http.NewRequest("POST", "http://example.com/t1", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/msgpack")
+	req.Header.Set("Accept", "application/msgpack")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusCreated)
+	assert.Equal(w.Header().Get("Content-Type"), "application/msgpack")
+	var created map[string]interface{}
+	assert.Nil(msgpack.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(created["b"], "mp")
+
+	body, _ = cbor.Marshal(map[string]interface{}{"a": "cb"})
+	req, _ = http.NewRequest("PUT", "http://example.com/t1?b=mp", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/cbor")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusNoContent)
+
+	req, _ = http.NewRequest("GET", "http://example.com/t1?b=mp", nil)
+	req.Header.Set("Accept", "application/cbor")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Header().Get("Content-Type"), "application/cbor")
+	data := []TestData{}
+	assert.Nil(cbor.Unmarshal(w.Body.Bytes(), &data))
+	assert.Equal(data, []TestData{{"cb", "mp"}})
+
+	*allowRaw = true
+	defer func() { *allowRaw = false }()
+	body, _ = cbor.Marshal(map[string]interface{}{
+		"read": "SELECT a FROM t1 WHERE b = ? AND 1 = ?",
+		"args": []interface{}{"mp", 1},
+	})
+	req, _ = http.NewRequest("POST", "http://example.com/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/cbor")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	assert.JSONEq(w.Body.String(), `[{"a": "cb"}]`)
+
+	req, _ = http.NewRequest("POST", "http://example.com/t1", bytes.NewBufferString("<a/>"))
+	req.Header.Set("Content-Type", "application/xml")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusUnsupportedMediaType)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressor is a streaming encoder for a content encoding.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// encoders are the supported response content encodings, in order of
+// preference when a client accepts several equally.
+var encoders = []struct {
+	name string
+	new  func(w io.Writer) compressor
+}{
+	{"br", func(w io.Writer) compressor { return brotli.NewWriter(w) }},
+	{"gzip", func(w io.Writer) compressor { return gzip.NewWriter(w) }},
+	{"deflate", func(w io.Writer) compressor { return zlib.NewWriter(w) }},
+}
+
+// acceptEncoding returns the index in encoders of the encoding an
+// Accept-Encoding header prefers, or -1 for none.
+func acceptEncoding(header string) int {
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = f
+				}
+			}
+		}
+		qualities[name] = q
+	}
+
+	best, bestQ := -1, 0.0
+	for i, enc := range encoders {
+		q, ok := qualities[enc.name]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = i, q
+		}
+	}
+	return best
+}
+
+// compressWriter compresses a response once it grows past -compress-min
+// bytes. Smaller responses are sent as they are. A response flushed before
+// reaching the threshold is taken to be streaming and is compressed from
+// then on, flushing the encoder with every flush.
+type compressWriter struct {
+	http.ResponseWriter
+	newEncoder func(w io.Writer) compressor
+	encoding   string
+	status     int
+	buf        []byte
+	started    bool
+	enc        compressor
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.started || cw.status != 0 {
+		return
+	}
+	cw.status = status
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.start(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if cw.started {
+		if cw.enc != nil {
+			return cw.enc.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= *compressMin {
+		if err := cw.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// start sends the headers and the buffered body, compressed or not.
+func (cw *compressWriter) start(compress bool) error {
+	cw.started = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	header := cw.Header()
+	if compress && header.Get("Content-Encoding") == "" {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		cw.ResponseWriter.WriteHeader(cw.status)
+		cw.enc = cw.newEncoder(cw.ResponseWriter)
+		_, err := cw.enc.Write(cw.buf)
+		cw.buf = nil
+		return err
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	_, err := cw.ResponseWriter.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.started {
+		cw.start(true)
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("response does not support hijacking")
+}
+
+// Close sends what is left of the response.
+func (cw *compressWriter) Close() error {
+	if !cw.started {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			return nil
+		}
+		if err := cw.start(len(cw.buf) >= *compressMin); err != nil {
+			return err
+		}
+	}
+	if cw.enc != nil {
+		return cw.enc.Close()
+	}
+	return nil
+}
+
+// errBodyTooLarge is returned reading a decompressed request body past
+// -max-decompressed bytes.
+var errBodyTooLarge = errors.New("request body is too large once decompressed")
+
+// limitedBody is a request body that fails with errBodyTooLarge after n
+// bytes.
+type limitedBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.n < 0 {
+		return 0, errBodyTooLarge
+	}
+	// Read a byte past the limit to tell a body of exactly n bytes from a
+	// longer one
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.n {
+		n, b.n = int(b.n), -1
+		return n, errBodyTooLarge
+	}
+	b.n -= int64(n)
+	return n, err
+}
+
+// withCompression compresses responses with the best encoding the client
+// accepts and decompresses gzip request bodies, up to -max-decompressed
+// bytes.
+func withCompression(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch strings.ToLower(r.Header.Get("Content-Encoding")) {
+		case "", "identity":
+		case "gzip":
+			body, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = body
+			if *maxDecompressed > 0 {
+				r.Body = &limitedBody{ReadCloser: body, n: *maxDecompressed}
+			}
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1
+		default:
+			http.Error(w, "unsupported Content-Encoding", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		if *compressMin < 0 {
+			h(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		i := acceptEncoding(r.Header.Get("Accept-Encoding"))
+		if i < 0 {
+			h(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			newEncoder:     encoders[i].new,
+			encoding:       encoders[i].name,
+		}
+		defer cw.Close()
+		h(cw, r)
+	}
+}
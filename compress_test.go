@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptEncoding(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(acceptEncoding(""), -1)
+	assert.Equal(acceptEncoding("identity"), -1)
+	assert.Equal(encoders[acceptEncoding("gzip, deflate, br")].name, "br")
+	assert.Equal(encoders[acceptEncoding("gzip;q=1.0, br;q=0.5")].name, "gzip")
+	assert.Equal(encoders[acceptEncoding("deflate")].name, "deflate")
+	assert.Equal(encoders[acceptEncoding("*, br;q=0")].name, "gzip")
+}
+
+func TestCompression(t *testing.T) {
+	assert := assert.New(t)
+	big := strings.Repeat("sqld ", 1000)
+
+	handler := withCompression(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	})
+	send := func(body, contentEncoding, acceptEncoding string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "http://example.com/t1", bytes.NewBufferString(body))
+		if contentEncoding != "" {
+			req.Header.Set("Content-Encoding", contentEncoding)
+		}
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	w := send("small", "", "gzip")
+	assert.Equal(w.Code, http.StatusCreated)
+	assert.Equal(w.Header().Get("Content-Encoding"), "")
+	assert.Equal(w.Header().Get("Vary"), "Accept-Encoding")
+	assert.Equal(w.Body.String(), "small")
+
+	w = send(big, "", "gzip")
+	assert.Equal(w.Code, http.StatusCreated)
+	assert.Equal(w.Header().Get("Content-Encoding"), "gzip")
+	gz, err := gzip.NewReader(w.Body)
+	assert.Nil(err)
+	body, _ := ioutil.ReadAll(gz)
+	assert.Equal(string(body), big)
+
+	w = send(big, "", "deflate")
+	zr, err := zlib.NewReader(w.Body)
+	assert.Nil(err)
+	body, _ = ioutil.ReadAll(zr)
+	assert.Equal(string(body), big)
+
+	w = send(big, "", "br")
+	assert.Equal(w.Header().Get("Content-Encoding"), "br")
+	body, _ = ioutil.ReadAll(brotli.NewReader(w.Body))
+	assert.Equal(string(body), big)
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte("from a gzip body"))
+	zw.Close()
+	w = send(compressed.String(), "gzip", "")
+	assert.Equal(w.Body.String(), "from a gzip body")
+
+	// A body of exactly the limit is read in full
+	*maxDecompressed = 16
+	defer func() { *maxDecompressed = 256 << 20 }()
+	w = send(compressed.String(), "gzip", "")
+	assert.Equal(w.Body.String(), "from a gzip body")
+
+	w = send("not gzip", "gzip", "")
+	assert.Equal(w.Code, http.StatusBadRequest)
+
+	w = send("zzz", "compress", "")
+	assert.Equal(w.Code, http.StatusUnsupportedMediaType)
+}
+
+func TestMaxDecompressed(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := withCompression(handleQuery)
+
+	createDB()
+	defer closeDB()
+
+	*maxDecompressed = 32
+	defer func() { *maxDecompressed = 256 << 20 }()
+
+	gzipped := func(body string) *bytes.Buffer {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write([]byte(body))
+		zw.Close()
+		return &b
+	}
+
+	req, _ := http.NewRequest("POST", "http://example.com/t1", gzipped(`{"a": "`+strings.Repeat("a", 100)+`", "b": "big"}`))
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(w.Code, http.StatusRequestEntityTooLarge)
+
+	req, _ = http.NewRequest("POST", "http://example.com/t1", gzipped("a,b\r\n"+strings.Repeat("x,y\r\n", 20)))
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Content-Type", "text/csv")
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(w.Code, http.StatusRequestEntityTooLarge)
+
+	req, _ = http.NewRequest("POST", "http://example.com/t1", gzipped(`{"a": "small", "b": "ok"}`))
+	req.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(w.Code, http.StatusCreated)
+
+	var count int
+	db.Get(&count, "SELECT COUNT(*) FROM t1")
+	assert.Equal(count, 3)
+}
+
+func TestCompressStreaming(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := withCompression(handleQuery)
+
+	createDB()
+	defer closeDB()
+
+	req, _ := http.NewRequest("GET", "http://example.com/t1?__format__=ndjson", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	assert.Equal(w.Header().Get("Content-Encoding"), "gzip")
+	assert.True(w.Flushed)
+	gz, err := gzip.NewReader(w.Body)
+	assert.Nil(err)
+	body, _ := ioutil.ReadAll(gz)
+	assert.Equal(string(body), "{\"a\":\"hi\",\"b\":\"there\"}\n{\"a\":\"how\",\"b\":\"dy\"}\n")
+
+	req, _ = http.NewRequest("DELETE", "http://example.com/t1/nope", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(w.Header().Get("Content-Encoding"), "")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// envelopeColumn describes a column of an envelope response.
+type envelopeColumn struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable *bool  `json:"nullable"`
+}
+
+// envelopeEncoder writes the application/vnd.sqld+json envelope: the
+// result's columns in order, each row as an array of values in the same
+// order, and the url of the next page when the request set a __limit__
+// that the result filled.
+//
+//	{
+//	  "columns": [{"name": "id", "type": "INTEGER", "nullable": false}],
+//	  "rows": [[1], [2]],
+//	  "next": "/table_name?__limit__=2&__offset__=2"
+//	}
+type envelopeEncoder struct {
+	w    io.Writer
+	r    *http.Request
+	rows int
+}
+
+func newEnvelopeEncoder(w io.Writer, r *http.Request) rowEncoder {
+	return &envelopeEncoder{w: w, r: r}
+}
+
+func (e *envelopeEncoder) Begin(columns []*sql.ColumnType) error {
+	meta := make([]envelopeColumn, len(columns))
+	for i, ct := range columns {
+		meta[i] = envelopeColumn{Name: ct.Name(), Type: ct.DatabaseTypeName()}
+		if nullable, ok := ct.Nullable(); ok {
+			meta[i].Nullable = &nullable
+		}
+	}
+
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(e.w, `{"columns":`+string(b)+`,"rows":[`)
+	return err
+}
+
+func (e *envelopeEncoder) Row(values []interface{}) error {
+	if e.rows > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.rows++
+
+	b, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
+
+func (e *envelopeEncoder) End() error {
+	next, err := json.Marshal(nextPage(e.r, e.rows))
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(e.w, `],"next":`+string(next)+"}\n")
+	return err
+}
+
+// nextPage returns the url of the page after a result of count rows, or
+// nil when the request was not paginated with __limit__ or the result
+// was the last page.
+func nextPage(r *http.Request, count int) interface{} {
+	values := r.URL.Query()
+	limit, err := strconv.Atoi(values.Get("__limit__"))
+	if err != nil || limit <= 0 || count < limit {
+		return nil
+	}
+
+	offset, _ := strconv.Atoi(values.Get("__offset__"))
+	values.Set("__offset__", strconv.Itoa(offset+limit))
+	next := *r.URL
+	next.RawQuery = values.Encode()
+	return next.RequestURI()
+}
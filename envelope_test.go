@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextPage(t *testing.T) {
+	assert := assert.New(t)
+
+	req, _ := http.NewRequest("GET", "http://example.com/t1?a=hi", nil)
+	assert.Nil(nextPage(req, 10))
+
+	req, _ = http.NewRequest("GET", "http://example.com/t1?__limit__=10", nil)
+	assert.Nil(nextPage(req, 9))
+	assert.Equal(nextPage(req, 10), "/t1?__limit__=10&__offset__=10")
+
+	req, _ = http.NewRequest("GET", "http://example.com/t1?__limit__=10&__offset__=20&a=hi", nil)
+	assert.Equal(nextPage(req, 10), "/t1?__limit__=10&__offset__=30&a=hi")
+}
+
+func TestEnvelope(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+	db.MustExec("CREATE TABLE typed(id INTEGER, name TEXT)")
+	db.MustExec("INSERT INTO typed VALUES (1, 'one'), (2, NULL), (3, 'three')")
+
+	req, _ := http.NewRequest("GET", "http://example.com/typed?__limit__=2", nil)
+	req.Header.Set("Accept", "application/vnd.sqld+json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	assert.Equal(w.Header().Get("Content-Type"), "application/vnd.sqld+json")
+	assert.JSONEq(w.Body.String(), `{
+		"columns": [
+			{"name": "id", "type": "INTEGER", "nullable": true},
+			{"name": "name", "type": "TEXT", "nullable": true}
+		],
+		"rows": [[1, "one"], [2, null]],
+		"next": "/typed?__limit__=2&__offset__=2"
+	}`)
+
+	req, _ = http.NewRequest("GET", "http://example.com/typed?__limit__=2&__offset__=2&__format__=sqld", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.JSONEq(w.Body.String(), `{
+		"columns": [
+			{"name": "id", "type": "INTEGER", "nullable": true},
+			{"name": "name", "type": "TEXT", "nullable": true}
+		],
+		"rows": [[3, "three"]],
+		"next": null
+	}`)
+}
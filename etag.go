@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+)
+
+// versioned reports whether rows of table carry the configured version
+// column.
+func versioned(table string) bool {
+	return *versionColumn != "" && hasColumn(table, *versionColumn)
+}
+
+// rowETag builds the entity tag for a row. Rows with a version column are
+// tagged with their version, all others with a hash of the row contents.
+func rowETag(row map[string]interface{}) string {
+	if *versionColumn != "" {
+		if v, ok := row[*versionColumn]; ok && v != nil {
+			return fmt.Sprintf(`"%v"`, v)
+		}
+	}
+
+	b, _ := json.Marshal(row)
+	sum := sha1.Sum(b)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// setETag adds an ETag header to responses for a single row.
+func setETag(w http.ResponseWriter, r *http.Request, data interface{}) {
+	if _, _, id := parseRequest(r); id == "" {
+		return
+	}
+	if rows, ok := data.([]map[string]interface{}); ok && len(rows) == 1 {
+		w.Header().Set("ETag", rowETag(rows[0]))
+	}
+}
+
+// ifMatch returns the entity tags of a request's If-Match header.
+// wildcard is true when the header is "*".
+func ifMatch(r *http.Request) (tags []string, wildcard bool) {
+	header := r.Header.Get("If-Match")
+	if strings.TrimSpace(header) == "*" {
+		return nil, true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		// Weak tags never match under the strong comparison If-Match uses
+		if tag == "" || strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	return tags, false
+}
+
+// versionCondition returns the WHERE clause that restricts a write to rows
+// whose version matches the request's If-Match header, or nil when the
+// write is unconditional or the table is not versioned.
+func versionCondition(r *http.Request, table string) squirrel.Sqlizer {
+	tags, wildcard := ifMatch(r)
+	if wildcard || len(tags) == 0 || !versioned(table) {
+		return nil
+	}
+
+	versions := make([]string, len(tags))
+	for i, tag := range tags {
+		versions[i] = strings.Trim(tag, `"`)
+	}
+	return squirrel.Eq{*versionColumn: versions}
+}
+
+// checkIfMatch verifies the If-Match header of a write against the rows it
+// targets on tables without a version column. It must run in the same
+// transaction as the write.
+func checkIfMatch(ext sqlx.Ext, r *http.Request) *SqldError {
+	table, _, _ := parseRequest(r)
+	tags, wildcard := ifMatch(r)
+	if wildcard || len(tags) == 0 || versioned(table) {
+		return nil
+	}
+
+	sql, args, err := buildSelectQuery(r)
+	if err != nil {
+		return BadRequest(err)
+	}
+	if *dbtype == "mysql" || *dbtype == "postgres" {
+		sql += " FOR UPDATE"
+	}
+
+	rows, err := readQuery(ext, sql, args)
+	if err != nil {
+		return InternalError(err)
+	}
+	if len(rows) == 0 {
+		return PreconditionFailed(nil)
+	}
+
+	for _, row := range rows {
+		etag := rowETag(row)
+		matched := false
+		for _, tag := range tags {
+			if tag == etag {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return PreconditionFailed(fmt.Errorf("row changed, current ETag is %s", etag))
+		}
+	}
+	return nil
+}
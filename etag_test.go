@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createItems() {
+	createDB()
+	db.MustExec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, version INTEGER NOT NULL DEFAULT 1)")
+	db.MustExec("INSERT INTO items (name) VALUES ('first')")
+}
+
+func requestETag(handler http.Handler, method, target, etag string) *httptest.ResponseRecorder {
+	var body *bytes.Buffer
+	if method == "PUT" {
+		body = bytes.NewBufferString(`{"name": "changed"}`)
+	} else {
+		body = &bytes.Buffer{}
+	}
+	req, _ := http.NewRequest(method, target, body)
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestIfMatch(t *testing.T) {
+	assert := assert.New(t)
+
+	req, _ := http.NewRequest("PUT", "http://example.com/items/1", nil)
+	tags, wildcard := ifMatch(req)
+	assert.Nil(tags)
+	assert.False(wildcard)
+
+	req.Header.Set("If-Match", `"a", W/"b",  "c"`)
+	tags, wildcard = ifMatch(req)
+	assert.Equal(tags, []string{`"a"`, `"c"`})
+	assert.False(wildcard)
+
+	req.Header.Set("If-Match", "*")
+	_, wildcard = ifMatch(req)
+	assert.True(wildcard)
+}
+
+func TestHashETag(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createItems()
+	defer closeDB()
+
+	w := requestETag(handler, "GET", "http://example.com/items/1", "")
+	etag := w.Header().Get("ETag")
+	assert.Len(etag, 42)
+
+	w = requestETag(handler, "GET", "http://example.com/items", "")
+	assert.Equal(w.Header().Get("ETag"), "")
+
+	w = requestETag(handler, "PUT", "http://example.com/items/1", `"stale"`)
+	assert.Equal(w.Code, http.StatusPreconditionFailed)
+
+	w = requestETag(handler, "PUT", "http://example.com/items/1", etag)
+	assert.Equal(w.Code, http.StatusNoContent)
+
+	w = requestETag(handler, "DELETE", "http://example.com/items/1", etag)
+	assert.Equal(w.Code, http.StatusPreconditionFailed)
+
+	w = requestETag(handler, "DELETE", "http://example.com/items/2", "*")
+	assert.Equal(w.Code, http.StatusPreconditionFailed)
+
+	w = requestETag(handler, "GET", "http://example.com/items/1", "")
+	w = requestETag(handler, "DELETE", "http://example.com/items/1", w.Header().Get("ETag"))
+	assert.Equal(w.Code, http.StatusNoContent)
+}
+
+func TestVersionETag(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createItems()
+	defer closeDB()
+
+	*versionColumn = "version"
+	defer func() { *versionColumn = "" }()
+
+	w := requestETag(handler, "GET", "http://example.com/items/1", "")
+	assert.Equal(w.Header().Get("ETag"), `"1"`)
+
+	w = requestETag(handler, "PUT", "http://example.com/items/1", `"1"`)
+	assert.Equal(w.Code, http.StatusNoContent)
+
+	w = requestETag(handler, "GET", "http://example.com/items/1", "")
+	assert.Equal(w.Header().Get("ETag"), `"2"`)
+
+	w = requestETag(handler, "PUT", "http://example.com/items/1", `"1"`)
+	assert.Equal(w.Code, http.StatusPreconditionFailed)
+
+	w = requestETag(handler, "DELETE", "http://example.com/items/1", `"1"`)
+	assert.Equal(w.Code, http.StatusPreconditionFailed)
+
+	w = requestETag(handler, "DELETE", "http://example.com/items/1", `"2"`)
+	assert.Equal(w.Code, http.StatusNoContent)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// eventStream is a GET request served as Server-Sent Events: the rows the
+// request matches followed by the changes to them. When the client
+// resumes from a change the rows are left out.
+type eventStream struct {
+	sub     *subscription
+	backlog []*change
+	// reset explains why a client that asked to resume is sent the rows
+	// again
+	reset   string
+	rows    *sql.Rows
+	release func()
+}
+
+// wantsEvents reports whether a GET request asks for Server-Sent Events.
+func wantsEvents(r *http.Request) bool {
+	return negotiate(r, []string{"application/json", "text/event-stream"}) == "text/event-stream"
+}
+
+// readEvents handles a GET request for Server-Sent Events. The
+// subscription starts before the rows are read, so no change made while
+// they are sent is missed, though some may already show in the rows.
+func readEvents(r *http.Request) (interface{}, *SqldError) {
+	if *changeLogSize <= 0 {
+		return nil, NotFound(errors.New("change subscriptions are disabled, start sqld with -changes"))
+	}
+	if withDeleted(r) && !privileged(r) {
+		return nil, Forbidden(errors.New("__with_deleted__ requires the admin token"))
+	}
+
+	table, _, id := parseRequest(r)
+	filter, since, sqldErr := changeFilter(r, table)
+	if sqldErr != nil {
+		return nil, sqldErr
+	}
+	if id != "" {
+		filter["id"] = []string{id}
+	}
+	if id, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		since = &id
+	}
+
+	stream := &eventStream{}
+	if since != nil {
+		sub, backlog, err := changes.subscribe(table, filter, since)
+		if err == nil {
+			stream.sub, stream.backlog = sub, backlog
+			return stream, nil
+		}
+		if err.Code != http.StatusGone {
+			return nil, err
+		}
+		stream.reset = err.Error()
+	}
+
+	sub, _, sqldErr := changes.subscribe(table, filter, nil)
+	if sqldErr != nil {
+		return nil, sqldErr
+	}
+	stream.sub = sub
+
+	query, args, err := buildSelectQuery(r)
+	if err != nil {
+		changes.unsubscribe(sub)
+		return nil, BadRequest(err)
+	}
+	ext, release, sqldErr := executor(r)
+	if sqldErr != nil {
+		changes.unsubscribe(sub)
+		return nil, sqldErr
+	}
+	stream.rows, err = ext.(sqlx.QueryerContext).QueryContext(r.Context(), query, args...)
+	if err != nil {
+		release()
+		changes.unsubscribe(sub)
+		return nil, BadRequest(err)
+	}
+	stream.release = release
+	return stream, nil
+}
+
+// writeEvent writes a Server-Sent Event. data must not hold newlines.
+func writeEvent(w io.Writer, id uint64, event string, data []byte) error {
+	if id > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// serve sends the stream until the client goes away. A "reset" event
+// tells a client its resume point is gone, then each row is sent as a
+// "row" event and a "ready" event, carrying the id to resume from, ends
+// the rows. Each change is then sent as a "change" event whose id is its
+// sequence number, so clients reconnecting with Last-Event-ID resume
+// where they left off. Subscribers that fall behind are disconnected and
+// resume the same way.
+func (s *eventStream) serve(w http.ResponseWriter, r *http.Request) error {
+	defer changes.unsubscribe(s.sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	out := bufio.NewWriter(w)
+	flush := func() error {
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return nil
+	}
+
+	if s.reset != "" {
+		data, _ := json.Marshal(s.reset)
+		if err := writeEvent(out, 0, "reset", data); err != nil {
+			return err
+		}
+	}
+	if s.rows != nil {
+		count, err := s.sendRows(r, out, flush)
+		s.release()
+		if err != nil {
+			return err
+		}
+		if err := writeEvent(out, s.sub.start, "ready", []byte(fmt.Sprintf(`{"rows":%d}`, count))); err != nil {
+			return err
+		}
+	}
+
+	send := func(c *change) error {
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		return writeEvent(out, c.Seq, "change", data)
+	}
+	for _, c := range s.backlog {
+		if err := send(c); err != nil {
+			return err
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case c, ok := <-s.sub.events:
+			if !ok {
+				return nil
+			}
+			if err := send(c); err != nil {
+				return err
+			}
+		case <-ping.C:
+			if _, err := io.WriteString(out, ": ping\n\n"); err != nil {
+				return err
+			}
+		case <-r.Context().Done():
+			return nil
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// sendRows sends each row read for the stream as a "row" event, flushing
+// what has been written every flushInterval.
+func (s *eventStream) sendRows(r *http.Request, out io.Writer, flush func() error) (int, error) {
+	defer s.rows.Close()
+
+	columns, err := s.rows.ColumnTypes()
+	if err != nil {
+		return 0, err
+	}
+	names := columnKeys(columns)
+	kinds := columnKindsOf(columns)
+
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+	count := 0
+	flushed := time.Now()
+	var buf bytes.Buffer
+	for s.rows.Next() {
+		if err := s.rows.Scan(valuePtrs...); err != nil {
+			return count, err
+		}
+		for i, v := range values {
+			values[i] = columnValue(kinds[i], v)
+		}
+		buf.Reset()
+		if err := jsonObject(&buf, names, values); err != nil {
+			return count, err
+		}
+		if err := writeEvent(out, 0, "row", buf.Bytes()); err != nil {
+			return count, err
+		}
+		count++
+		if time.Since(flushed) >= flushInterval {
+			if err := flush(); err != nil {
+				return count, err
+			}
+			flushed = time.Now()
+		}
+	}
+	return count, s.rows.Err()
+}
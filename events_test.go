@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type serverEvent struct {
+	id, event, data string
+}
+
+func readEvent(r *bufio.Reader) serverEvent {
+	var ev serverEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return ev
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if ev.event != "" {
+				return ev
+			}
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+
+	req, _ := http.NewRequest("GET", "http://example.com/t1", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusNotFound)
+
+	*changeLogSize = 100
+	defer func() { *changeLogSize = 0 }()
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	subscribe := func(lastEventID string) (*bufio.Reader, func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/t1?a=hi&a=new", nil)
+		req.Header.Set("Accept", "text/event-stream")
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(err)
+		assert.Equal(res.Header.Get("Content-Type"), "text/event-stream")
+		return bufio.NewReader(res.Body), func() {
+			cancel()
+			res.Body.Close()
+		}
+	}
+
+	events, stop := subscribe("")
+	defer stop()
+	assert.Equal(readEvent(events), serverEvent{event: "row", data: `{"a":"hi","b":"there"}`})
+	ready := readEvent(events)
+	assert.Equal(ready.event, "ready")
+	assert.Equal(ready.data, `{"rows":1}`)
+
+	write := func(method, path, body string) int {
+		req, _ := http.NewRequest(method, "http://example.com"+path, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(write("POST", "/t1", `{"a":"other","b":"x"}`), http.StatusCreated)
+	assert.Equal(write("POST", "/t1", `{"a":"new","b":"y"}`), http.StatusCreated)
+	ev := readEvent(events)
+	assert.Equal(ev.event, "change")
+	assert.Contains(ev.data, `"op":"insert","row":{"a":"new","b":"y","id":4}`)
+	assert.NotEqual(ev.id, ready.id)
+
+	assert.Equal(write("DELETE", "/t1?a=hi", ""), http.StatusNoContent)
+	deleted := readEvent(events)
+	assert.Contains(deleted.data, `"op":"delete"`)
+
+	resumed, stopResumed := subscribe(ev.id)
+	defer stopResumed()
+	assert.Equal(readEvent(resumed), deleted)
+
+	// A single row's stream only gets changes to that row
+	db.MustExec("CREATE TABLE t2(id INTEGER PRIMARY KEY, name TEXT)")
+	db.MustExec("INSERT INTO t2(name) VALUES ('one'), ('two')")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ = http.NewRequestWithContext(ctx, "GET", server.URL+"/t2/2", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(err)
+	defer res.Body.Close()
+	single := bufio.NewReader(res.Body)
+	assert.Equal(readEvent(single), serverEvent{event: "row", data: `{"id":2,"name":"two"}`})
+	assert.Equal(readEvent(single).event, "ready")
+	assert.Equal(write("DELETE", "/t2/1", ""), http.StatusNoContent)
+	assert.Equal(write("PUT", "/t2/2", `{"name":"deux"}`), http.StatusNoContent)
+	ev = readEvent(single)
+	assert.Equal(ev.event, "change")
+	assert.Contains(ev.data, `"op":"update"`)
+	assert.Contains(ev.data, `"name":"deux"`)
+
+	reset, stopReset := subscribe("1")
+	defer stopReset()
+	assert.Equal(readEvent(reset).event, "reset")
+	assert.Equal(readEvent(reset), serverEvent{event: "row", data: `{"a":"new","b":"y"}`})
+	assert.Equal(readEvent(reset).event, "ready")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// explainMode returns the __explain__ parameter of a request. Requests with
+// a mode describe the query they would run instead of running it:
+//
+//	sql      the generated statement and its bound arguments
+//	plan     the database's query plan for the statement
+//	analyze  the plan along with actual run times, reads only
+func explainMode(r *http.Request) string {
+	return r.URL.Query().Get("__explain__")
+}
+
+// explainPrefix returns the statement prefix that asks the database for a
+// query plan, and whether the database answers with a single JSON
+// document.
+func explainPrefix(analyze bool) (string, bool, error) {
+	switch *dbtype {
+	case "postgres":
+		if analyze {
+			return "EXPLAIN (ANALYZE, FORMAT JSON) ", true, nil
+		}
+		return "EXPLAIN (FORMAT JSON) ", true, nil
+	case "mysql":
+		if analyze {
+			return "EXPLAIN ANALYZE ", false, nil
+		}
+		return "EXPLAIN FORMAT=JSON ", true, nil
+	case "sqlite3":
+		if analyze {
+			return "", false, errors.New("sqlite3 does not support EXPLAIN ANALYZE")
+		}
+		return "EXPLAIN QUERY PLAN ", false, nil
+	}
+	return "", false, errors.New("Unsupported database type " + *dbtype)
+}
+
+// explain responds with the statement built for a request and, when asked,
+// the plan the database would use to run it.
+func explain(r *http.Request, sql string, args []interface{}) (interface{}, *SqldError) {
+	if args == nil {
+		args = []interface{}{}
+	}
+	result := map[string]interface{}{
+		"sql":  sql,
+		"args": args,
+	}
+
+	mode := explainMode(r)
+	switch mode {
+	case "sql":
+		return result, nil
+	case "plan", "analyze":
+	default:
+		return nil, BadRequest(fmt.Errorf("unknown __explain__ mode %q", mode))
+	}
+
+	// EXPLAIN ANALYZE runs the statement, which must not happen for writes
+	if mode == "analyze" && r.Method != "GET" {
+		return nil, BadRequest(errors.New("__explain__=analyze is only supported for reads"))
+	}
+
+	prefix, jsonPlan, err := explainPrefix(mode == "analyze")
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	ext, release, sqldErr := executor(r)
+	if sqldErr != nil {
+		return nil, sqldErr
+	}
+	defer release()
+
+	rows, err := readQuery(ext, prefix+sql, args)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	result["plan"] = rows
+	if jsonPlan && len(rows) == 1 && len(rows[0]) == 1 {
+		for _, v := range rows[0] {
+			if s, ok := v.(string); ok && json.Valid([]byte(s)) {
+				result["plan"] = json.RawMessage(s)
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplainPrefix(t *testing.T) {
+	assert := assert.New(t)
+	defer func() { *dbtype = "sqlite3" }()
+
+	*dbtype = "postgres"
+	prefix, jsonPlan, err := explainPrefix(true)
+	assert.Nil(err)
+	assert.True(jsonPlan)
+	assert.Equal(prefix, "EXPLAIN (ANALYZE, FORMAT JSON) ")
+
+	*dbtype = "mysql"
+	prefix, jsonPlan, err = explainPrefix(false)
+	assert.Nil(err)
+	assert.True(jsonPlan)
+	assert.Equal(prefix, "EXPLAIN FORMAT=JSON ")
+
+	*dbtype = "sqlite3"
+	_, _, err = explainPrefix(true)
+	assert.NotNil(err)
+}
+
+func TestExplain(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+
+	req, _ := http.NewRequest("GET", "http://example.com/t1?a=hi&__explain__=sql", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	var result map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &result)
+	assert.Equal(result["sql"], "SELECT * FROM t1 WHERE a IN (?)")
+	assert.Equal(result["args"], []interface{}{"hi"})
+
+	req, _ = http.NewRequest("GET", "http://example.com/t1?a=hi&__explain__=plan", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	result = nil
+	json.Unmarshal(w.Body.Bytes(), &result)
+	plan := result["plan"].([]interface{})
+	assert.Contains(plan[0].(map[string]interface{})["detail"], "t1")
+
+	req, _ = http.NewRequest("PUT", "http://example.com/t1?a=hi&__explain__=sql", bytes.NewBufferString(`{"b": "x"}`))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	result = nil
+	json.Unmarshal(w.Body.Bytes(), &result)
+	assert.Equal(result["sql"], "UPDATE t1 SET b = ? WHERE a IN (?)")
+
+	var b string
+	db.Get(&b, "SELECT b FROM t1 WHERE a = 'hi'")
+	assert.Equal(b, "there")
+
+	req, _ = http.NewRequest("DELETE", "http://example.com/t1?a=hi&__explain__=analyze", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusBadRequest)
+
+	req, _ = http.NewRequest("GET", "http://example.com/t1?__explain__=nope", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusBadRequest)
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// rowEncoder writes the rows of a query result in a response format as
+// they are read from the database.
+type rowEncoder interface {
+	// Begin is called once with the result's columns before any rows
+	Begin(columns []*sql.ColumnType) error
+	// Row is called with the values of each row, in column order, as
+	// converted by columnValue
+	Row(values []interface{}) error
+	// End is called once after the last row
+	End() error
+}
+
+// encoderFlusher is implemented by row encoders that buffer output of
+// their own, which must be written out whenever the response is flushed.
+type encoderFlusher interface {
+	Flush() error
+}
+
+// rowFormat is a response format query results can be streamed in.
+type rowFormat struct {
+	mediaType string
+	// extension names the file the response is downloaded as, formats
+	// without one are shown inline
+	extension  string
+	newEncoder func(w io.Writer, r *http.Request) rowEncoder
+}
+
+// rowFormats are the streamed response formats, keyed by the short name
+// accepted by the __format__ parameter.
+var rowFormats = map[string]*rowFormat{
+	"csv": {
+		mediaType:  "text/csv",
+		extension:  "csv",
+		newEncoder: newCSVEncoder,
+	},
+	"ndjson": {
+		mediaType:  "application/x-ndjson",
+		newEncoder: newNDJSONEncoder,
+	},
+	"sqld": {
+		mediaType:  "application/vnd.sqld+json",
+		newEncoder: newEnvelopeEncoder,
+	},
+}
+
+// jsonArrayFormat streams a plain JSON array of row objects. It is used
+// instead of building the whole result in memory when a JSON request
+// passes __stream__=true.
+var jsonArrayFormat = &rowFormat{
+	mediaType:  "application/json",
+	newEncoder: newJSONArrayEncoder,
+}
+
+// flushInterval is how often a streamed response is flushed to the client
+// while rows are still being read.
+const flushInterval = 250 * time.Millisecond
+
+// acceptRange is a media range of an Accept header with its quality.
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType, q})
+	}
+	return ranges
+}
+
+// acceptQuality returns the quality an Accept header gives a media type,
+// using the most specific matching range.
+func acceptQuality(ranges []acceptRange, mediaType string) float64 {
+	best, specificity := 0.0, -1
+	for _, ar := range ranges {
+		s := -1
+		switch {
+		case ar.mediaType == mediaType:
+			s = 2
+		case strings.HasSuffix(ar.mediaType, "/*") &&
+			strings.HasPrefix(mediaType, strings.TrimSuffix(ar.mediaType, "*")):
+			s = 1
+		case ar.mediaType == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			best, specificity = ar.q, s
+		}
+	}
+	return best
+}
+
+// negotiate picks the offered media type the request's Accept header
+// prefers. Ties go to the earliest offer, and requests without an Accept
+// header get the first offer.
+func negotiate(r *http.Request, offers []string) string {
+	header := r.Header.Get("Accept")
+	if header == "" || len(offers) == 0 {
+		if len(offers) == 0 {
+			return ""
+		}
+		return offers[0]
+	}
+
+	ranges := parseAccept(header)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(ranges, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// requestedRowFormat returns the streamed format a request asks for with
+// the __format__ parameter or its Accept header, or nil when the result
+// should be built in memory and sent as plain JSON.
+func requestedRowFormat(r *http.Request) (*rowFormat, *SqldError) {
+	stream, _ := strconv.ParseBool(r.URL.Query().Get("__stream__"))
+	var plain *rowFormat
+	if stream {
+		plain = jsonArrayFormat
+	}
+
+	if name := r.URL.Query().Get("__format__"); name != "" {
+		if name == "json" {
+			return plain, nil
+		}
+		format, ok := rowFormats[name]
+		if !ok {
+			return nil, BadRequest(fmt.Errorf("unknown __format__ %q", name))
+		}
+		return format, nil
+	}
+
+	offers := []string{"application/json"}
+	names := make([]string, 0, len(rowFormats))
+	for name := range rowFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		offers = append(offers, rowFormats[name].mediaType)
+	}
+
+	chosen := negotiate(r, offers)
+	for _, format := range rowFormats {
+		if format.mediaType == chosen {
+			return format, nil
+		}
+	}
+	return plain, nil
+}
+
+// resultRows is a query result that handleQuery streams to the client in
+// the requested format as the rows are read.
+type resultRows struct {
+	rows    *sql.Rows
+	name    string
+	format  *rowFormat
+	release func()
+}
+
+// streamQuery runs a query for a request that asked for a streamed
+// format. release is called once the rows have been written. The query is
+// cancelled if the client goes away before it has read every row.
+func streamQuery(r *http.Request, q sqlx.Queryer, format *rowFormat, name, query string, args []interface{}, release func()) (interface{}, *SqldError) {
+	var rows *sql.Rows
+	var err error
+	if qc, ok := q.(sqlx.QueryerContext); ok {
+		rows, err = qc.QueryContext(r.Context(), query, args...)
+	} else {
+		rows, err = q.Query(query, args...)
+	}
+	if err != nil {
+		release()
+		return nil, BadRequest(err)
+	}
+	return &resultRows{rows: rows, name: name, format: format, release: release}, nil
+}
+
+// writeRows streams a query result to the client, flushing what has been
+// written every flushInterval. Errors reading rows once the response has
+// started can only cut the response short.
+func writeRows(w http.ResponseWriter, r *http.Request, res *resultRows) error {
+	defer res.release()
+	defer res.rows.Close()
+
+	columns, err := res.rows.ColumnTypes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	w.Header().Set("Content-Type", res.format.mediaType)
+	if res.format.extension != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": res.name + "." + res.format.extension,
+		}))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	out := bufio.NewWriter(w)
+	enc := res.format.newEncoder(out, r)
+	flush := func() {
+		if f, ok := enc.(encoderFlusher); ok {
+			f.Flush()
+		}
+		out.Flush()
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	defer flush()
+
+	if err := enc.Begin(columns); err != nil {
+		return err
+	}
+	flush()
+	flushed := time.Now()
+
+	kinds := columnKindsOf(columns)
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+	for res.rows.Next() {
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		if err := res.rows.Scan(valuePtrs...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			values[i] = columnValue(kinds[i], v)
+		}
+		if err := enc.Row(values); err != nil {
+			return err
+		}
+		if time.Since(flushed) >= flushInterval {
+			flush()
+			flushed = time.Now()
+		}
+	}
+	if err := res.rows.Err(); err != nil {
+		return err
+	}
+	return enc.End()
+}
+
+// jsonObject writes a row as a JSON object with its keys in column order.
+func jsonObject(w io.Writer, names [][]byte, values []interface{}) error {
+	buf := []byte{'{'}
+	for i, v := range values {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, names[i]...)
+		buf = append(buf, ':')
+		buf = append(buf, b...)
+	}
+	buf = append(buf, '}')
+	_, err := w.Write(buf)
+	return err
+}
+
+// columnKeys returns the JSON encoded names of the columns.
+func columnKeys(columns []*sql.ColumnType) [][]byte {
+	names := make([][]byte, len(columns))
+	for i, c := range columns {
+		names[i], _ = json.Marshal(c.Name())
+	}
+	return names
+}
+
+// ndjsonEncoder writes each row as a JSON object on its own line.
+type ndjsonEncoder struct {
+	w     io.Writer
+	names [][]byte
+}
+
+func newNDJSONEncoder(w io.Writer, r *http.Request) rowEncoder {
+	return &ndjsonEncoder{w: w}
+}
+
+func (e *ndjsonEncoder) Begin(columns []*sql.ColumnType) error {
+	e.names = columnKeys(columns)
+	return nil
+}
+
+func (e *ndjsonEncoder) Row(values []interface{}) error {
+	if err := jsonObject(e.w, e.names, values); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "\n")
+	return err
+}
+
+func (e *ndjsonEncoder) End() error {
+	return nil
+}
+
+// jsonArrayEncoder writes the rows as a JSON array of objects, the same
+// response a JSON request gets without streaming.
+type jsonArrayEncoder struct {
+	w     io.Writer
+	names [][]byte
+	rows  int
+}
+
+func newJSONArrayEncoder(w io.Writer, r *http.Request) rowEncoder {
+	return &jsonArrayEncoder{w: w}
+}
+
+func (e *jsonArrayEncoder) Begin(columns []*sql.ColumnType) error {
+	e.names = columnKeys(columns)
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jsonArrayEncoder) Row(values []interface{}) error {
+	if e.rows > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.rows++
+	return jsonObject(e.w, e.names, values)
+}
+
+func (e *jsonArrayEncoder) End() error {
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+// csvEncoder writes RFC 4180 CSV with a header row of column names.
+type csvEncoder struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVEncoder(w io.Writer, r *http.Request) rowEncoder {
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	return &csvEncoder{w: cw}
+}
+
+func (e *csvEncoder) Begin(columns []*sql.ColumnType) error {
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.Name()
+	}
+	e.record = make([]string, len(columns))
+	return e.w.Write(header)
+}
+
+func (e *csvEncoder) Row(values []interface{}) error {
+	for i, v := range values {
+		e.record[i] = csvValue(v)
+	}
+	return e.w.Write(e.record)
+}
+
+func (e *csvEncoder) End() error {
+	return e.Flush()
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvValue formats a value from columnValue as a CSV field. NULL is
+// written as an empty field and binary data as base64.
+func csvValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return base64.StdEncoding.EncodeToString(val)
+	case string:
+		return val
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+	assert := assert.New(t)
+	offers := []string{"application/json", "text/csv"}
+
+	req, _ := http.NewRequest("GET", "http://example.com/t1", nil)
+	assert.Equal(negotiate(req, offers), "application/json")
+
+	req.Header.Set("Accept", "text/csv")
+	assert.Equal(negotiate(req, offers), "text/csv")
+
+	req.Header.Set("Accept", "text/*")
+	assert.Equal(negotiate(req, offers), "text/csv")
+
+	req.Header.Set("Accept", "text/csv;q=0.5, application/json")
+	assert.Equal(negotiate(req, offers), "application/json")
+
+	req.Header.Set("Accept", "*/*, text/csv;q=0")
+	assert.Equal(negotiate(req, offers), "application/json")
+
+	req.Header.Set("Accept", "image/png")
+	assert.Equal(negotiate(req, offers), "")
+}
+
+func TestRequestedRowFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	req, _ := http.NewRequest("GET", "http://example.com/t1", nil)
+	format, err := requestedRowFormat(req)
+	assert.Nil(err)
+	assert.Nil(format)
+
+	req.Header.Set("Accept", "text/csv")
+	format, err = requestedRowFormat(req)
+	assert.Nil(err)
+	assert.Equal(format, rowFormats["csv"])
+
+	req, _ = http.NewRequest("GET", "http://example.com/t1?__format__=json", nil)
+	req.Header.Set("Accept", "text/csv")
+	format, err = requestedRowFormat(req)
+	assert.Nil(err)
+	assert.Nil(format)
+
+	req, _ = http.NewRequest("GET", "http://example.com/t1?__format__=xml", nil)
+	_, err = requestedRowFormat(req)
+	assert.Equal(err.Code, http.StatusBadRequest)
+}
+
+func TestCSV(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+	db.MustExec("INSERT INTO t1 (a, b) VALUES ('say \"hi\", then', 'x')")
+	db.MustExec("INSERT INTO t1 (a, b) VALUES (NULL, 'y')")
+
+	req, _ := http.NewRequest("GET", "http://example.com/t1", nil)
+	req.Header.Set("Accept", "text/csv")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	assert.Equal(w.Header().Get("Content-Type"), "text/csv")
+	assert.Equal(w.Header().Get("Content-Disposition"), `attachment; filename=t1.csv`)
+	assert.Equal(w.Body.String(), "a,b\r\nhi,there\r\nhow,dy\r\n\"say \"\"hi\"\", then\",x\r\n,y\r\n")
+
+	req, _ = http.NewRequest("GET", "http://example.com/t1?b=dy&__format__=csv", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Body.String(), "a,b\r\nhow,dy\r\n")
+
+	req, _ = http.NewRequest("GET", "http://example.com/nope?__format__=csv", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.NotEqual(w.Code, http.StatusOK)
+
+	*allowRaw = true
+	defer func() { *allowRaw = false }()
+	body := bytes.NewBufferString(`{"read": "SELECT b, a FROM t1 WHERE a = ?", "args": ["hi"]}`)
+	req, _ = http.NewRequest("POST", "http://example.com/?__format__=csv", body)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	assert.Equal(w.Header().Get("Content-Disposition"), `attachment; filename=query.csv`)
+	assert.Equal(w.Body.String(), "b,a\r\nthere,hi\r\n")
+}
+
+// flushRecorder records the body sent by each flush.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes []string
+}
+
+func (w *flushRecorder) Flush() {
+	w.flushes = append(w.flushes, w.Body.String())
+	w.ResponseRecorder.Flush()
+}
+
+func TestCSVFlush(t *testing.T) {
+	assert := assert.New(t)
+
+	createDB()
+	defer closeDB()
+
+	req, _ := http.NewRequest("GET", "http://example.com/t1?__format__=csv", nil)
+	res, err := read(req)
+	assert.Nil(err)
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	assert.Nil(writeRows(w, req, res.(*resultRows)))
+	// The header row is sent before any rows are read
+	assert.Equal(w.flushes[0], "a,b\r\n")
+	assert.Equal(w.Body.String(), "a,b\r\nhi,there\r\nhow,dy\r\n")
+
+	var buf bytes.Buffer
+	enc := newCSVEncoder(&buf, req).(*csvEncoder)
+	enc.record = make([]string, 2)
+	assert.Nil(enc.Row([]interface{}{"hi", int64(1)}))
+	assert.Equal(buf.String(), "")
+	assert.Nil(enc.Flush())
+	assert.Equal(buf.String(), "hi,1\r\n")
+}
+
+func TestStreamJSON(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+
+	req, _ := http.NewRequest("GET", "http://example.com/t1", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	assert.Equal(w.Header().Get("Content-Type"), "application/x-ndjson")
+	assert.Equal(w.Header().Get("Content-Disposition"), "")
+	assert.Equal(w.Body.String(), "{\"a\":\"hi\",\"b\":\"there\"}\n{\"a\":\"how\",\"b\":\"dy\"}\n")
+	assert.True(w.Flushed)
+
+	req, _ = http.NewRequest("GET", "http://example.com/t1?__stream__=true", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Header().Get("Content-Type"), "application/json")
+	data := []TestData{}
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &data))
+	assert.Equal(data, []TestData{{"hi", "there"}, {"how", "dy"}})
+
+	req, _ = http.NewRequest("GET", "http://example.com/t1?__stream__=true&b=nope", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Body.String(), "[]\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ = http.NewRequest("GET", "http://example.com/t1?__format__=ndjson", nil)
+	req = req.WithContext(ctx)
+	res, err := read(req)
+	assert.Nil(err)
+	cancel()
+	w = httptest.NewRecorder()
+	assert.NotNil(writeRows(w, req, res.(*resultRows)))
+	assert.NotContains(w.Body.String(), "dy")
+}
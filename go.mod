@@ -0,0 +1,46 @@
+module github.com/mmaelzer/sqld
+
+go 1.25.0
+
+require (
+	github.com/Masterminds/squirrel v1.5.4
+	github.com/andybalholm/brotli v1.2.0
+	github.com/apache/arrow/go/arrow v0.0.0-20200730104253-651201b0f516
+	github.com/fxamacker/cbor/v2 v2.5.0
+	github.com/go-sql-driver/mysql v1.9.3
+	github.com/gorilla/websocket v1.5.1
+	github.com/graphql-go/graphql v0.8.1
+	github.com/jmoiron/sqlx v1.4.0
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.33
+	github.com/stretchr/testify v1.11.1
+	github.com/vmihailenco/msgpack/v4 v4.3.13
+	github.com/xitongsys/parquet-go v1.6.2
+	google.golang.org/grpc v1.82.1
+	google.golang.org/protobuf v1.36.11
+)
+
+require (
+	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/apache/thrift v0.14.2 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/golang/protobuf v1.5.4 // indirect
+	github.com/golang/snappy v0.0.3 // indirect
+	github.com/google/flatbuffers v1.11.0 // indirect
+	github.com/klauspost/compress v1.13.1 // indirect
+	github.com/lann/builder v0.0.0-20180802200727-47ae307949d0 // indirect
+	github.com/lann/ps v0.0.0-20150810152359-62de8c46ede0 // indirect
+	github.com/pierrec/lz4/v4 v4.1.8 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/vmihailenco/tagparser v0.1.1 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0 // indirect
+	golang.org/x/net v0.53.0 // indirect
+	golang.org/x/sys v0.43.0 // indirect
+	golang.org/x/text v0.36.0 // indirect
+	golang.org/x/xerrors v0.0.0-20240903120638-7835f813f4da // indirect
+	google.golang.org/appengine v1.6.5 // indirect
+	google.golang.org/genproto v0.0.0-20250603155806-513f23925822 // indirect; keeps googleapis/rpc out of the old monolithic module
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260414002931-afd174a4e478 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/jmoiron/sqlx"
+)
+
+// graphqlRoute is the url path, relative to the url prefix, that serves
+// the GraphQL endpoint
+const graphqlRoute = "_graphql"
+
+var (
+	graphqlOnce   sync.Once
+	graphqlSchema *graphql.Schema
+	graphqlErr    error
+
+	graphqlName = regexp.MustCompile(`^[_a-zA-Z][_a-zA-Z0-9]*$`)
+)
+
+// graphqlRequest is the body of a GraphQL request.
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// requestKey stores the http request in the context of GraphQL resolvers.
+type requestKey struct{}
+
+// graphqlArgs are the arguments a table field takes besides its columns.
+var graphqlArgs = map[string]bool{
+	"limit":    true,
+	"offset":   true,
+	"order_by": true,
+	"set":      true,
+	"values":   true,
+}
+
+// graphqlScalar returns the GraphQL type of a column kind.
+func graphqlScalar(kind string) graphql.Output {
+	switch kind {
+	case "int":
+		return graphql.Int
+	case "float":
+		return graphql.Float
+	case "bool":
+		return graphql.Boolean
+	}
+	return graphql.String
+}
+
+// graphqlRows converts rows from readQuery for GraphQL, which has no
+// binary type, by encoding binary values as base64.
+func graphqlRows(rows []map[string]interface{}) []map[string]interface{} {
+	for _, row := range rows {
+		for col, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[col] = base64.StdEncoding.EncodeToString(b)
+			}
+		}
+	}
+	if rows == nil {
+		return []map[string]interface{}{}
+	}
+	return rows
+}
+
+// graphqlFilter returns the equality conditions given as column arguments.
+func graphqlFilter(args map[string]interface{}) squirrel.Eq {
+	filter := squirrel.Eq{}
+	for key, val := range args {
+		if !graphqlArgs[key] {
+			filter[key] = val
+		}
+	}
+	return filter
+}
+
+// graphqlOrderBy checks an order_by argument, such as "name, id DESC",
+// names only columns of the table.
+func graphqlOrderBy(schema *tableSchema, orderBy string) ([]string, error) {
+	var order []string
+	for _, part := range strings.Split(orderBy, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid order_by %q", orderBy)
+		}
+		if _, ok := schema.kinds[fields[0]]; !ok {
+			return nil, fmt.Errorf("unknown column %s in order_by", fields[0])
+		}
+		if len(fields) == 2 {
+			dir := strings.ToUpper(fields[1])
+			if dir != "ASC" && dir != "DESC" {
+				return nil, fmt.Errorf("invalid order_by direction %s", fields[1])
+			}
+			fields[1] = dir
+		}
+		order = append(order, strings.Join(fields, " "))
+	}
+	return order, nil
+}
+
+// graphqlSelect reads the rows of a table matching filter.
+func graphqlSelect(ctx context.Context, table string, filter squirrel.Eq, args map[string]interface{}) ([]map[string]interface{}, error) {
+	r := ctx.Value(requestKey{}).(*http.Request)
+	query := sq.Select("*").From(table).Where(filter)
+	if cond := softDeleteCondition(r, table); cond != nil {
+		query = query.Where(cond)
+	}
+
+	if orderBy, ok := args["order_by"].(string); ok {
+		schema, err := loadTableSchema(table)
+		if err != nil {
+			return nil, err
+		}
+		order, err := graphqlOrderBy(schema, orderBy)
+		if err != nil {
+			return nil, err
+		}
+		query = query.OrderBy(order...)
+	}
+	if limit, ok := args["limit"].(int); ok {
+		query = query.Limit(uint64(limit))
+	}
+	if offset, ok := args["offset"].(int); ok {
+		query = query.Offset(uint64(offset))
+	}
+
+	sql, sqlArgs, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	ext, release, sqldErr := executor(r)
+	if sqldErr != nil {
+		return nil, sqldErr
+	}
+	defer release()
+
+	rows, err := readQuery(ext, sql, sqlArgs)
+	if err != nil {
+		return nil, err
+	}
+	return graphqlRows(rows), nil
+}
+
+// graphqlWrite runs an update or delete of the rows of a table matching
+// the filter arguments and returns the number of rows changed. Writes
+// without values are deletes.
+func graphqlWrite(ctx context.Context, table string, filter squirrel.Eq, values map[string]interface{}, build func() squirrel.UpdateBuilder) (interface{}, error) {
+	r := ctx.Value(requestKey{}).(*http.Request)
+	if len(filter) == 0 && !allowAllRows(r, table) {
+		return nil, errMassWrite
+	}
+
+	target := sq.Select("*").From(table).Where(filter)
+	if col := softDeleteColumn(table); col != "" {
+		target = target.Where(squirrel.Eq{col: nil})
+	}
+	op := "update"
+	if values == nil {
+		op = "delete"
+	}
+	w := selectWrite(table, op, target, values)
+
+	data, sqldErr := withTx(r, func(ext sqlx.Ext) (interface{}, *SqldError) {
+		var sql string
+		var args []interface{}
+		var err error
+		if build != nil {
+			sql, args, err = build().Where(filter).ToSql()
+		} else {
+			sql, args, err = sq.Delete(table).Where(filter).ToSql()
+		}
+		if err != nil {
+			return nil, BadRequest(err)
+		}
+
+		changed, err := w.before(ext)
+		if err != nil {
+			return nil, InternalError(err)
+		}
+		rows, sqldErr := execAffected(ext, sql, args)
+		if sqldErr != nil {
+			return nil, sqldErr
+		}
+		w.after(ext, changed)
+		return rows, nil
+	})
+	if sqldErr != nil {
+		return nil, sqldErr
+	}
+	return data, nil
+}
+
+// buildGraphQLSchema generates a GraphQL schema from the tables of the
+// database. Every table gets an object type, a query field taking its
+// columns as equality filters along with limit, offset and order_by, and
+// insert, update and delete mutations. Foreign keys add a field to the
+// referencing type for the row it references, and a field to the
+// referenced type listing the rows that reference it.
+func buildGraphQLSchema() (*graphql.Schema, error) {
+	names, err := listTables()
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := make(map[string]*tableSchema)
+	var tables []string
+	for _, table := range names {
+		if !graphqlName.MatchString(table) || strings.HasPrefix(table, "__") ||
+			table == idempotencyTable || table == "Query" || table == "Mutation" {
+			continue
+		}
+		schema, err := loadTableSchema(table)
+		if err != nil {
+			return nil, err
+		}
+		schemas[table] = schema
+		tables = append(tables, table)
+	}
+
+	// references lists, for each table, the foreign keys pointing at it
+	type reference struct {
+		table, column string
+		fk            foreignKey
+	}
+	fks := make(map[string]map[string]foreignKey)
+	references := make(map[string][]reference)
+	for _, table := range tables {
+		tableFKs, err := foreignKeys(table)
+		if err != nil {
+			return nil, err
+		}
+		fks[table] = tableFKs
+		for col, fk := range tableFKs {
+			if schemas[fk.Table] != nil {
+				references[fk.Table] = append(references[fk.Table], reference{table, col, fk})
+			}
+		}
+	}
+
+	objects := make(map[string]*graphql.Object)
+	inputs := make(map[string]*graphql.InputObject)
+	for _, table := range tables {
+		table, schema := table, schemas[table]
+
+		inputFields := graphql.InputObjectConfigFieldMap{}
+		for _, col := range schema.names {
+			if graphqlName.MatchString(col) {
+				inputFields[col] = &graphql.InputObjectFieldConfig{Type: graphqlScalar(schema.kinds[col])}
+			}
+		}
+		inputs[table] = graphql.NewInputObject(graphql.InputObjectConfig{
+			Name:   table + "_input",
+			Fields: inputFields,
+		})
+
+		objects[table] = graphql.NewObject(graphql.ObjectConfig{
+			Name: table,
+			Fields: graphql.FieldsThunk(func() graphql.Fields {
+				fields := graphql.Fields{}
+				for _, col := range schema.names {
+					if graphqlName.MatchString(col) {
+						fields[col] = &graphql.Field{Type: graphqlScalar(schema.kinds[col])}
+					}
+				}
+
+				for col, fk := range fks[table] {
+					col, fk := col, fk
+					if objects[fk.Table] == nil {
+						continue
+					}
+					name := strings.TrimSuffix(col, "_id")
+					if _, taken := fields[name]; taken || name == col {
+						name = col + "_" + fk.Table
+					}
+					fields[name] = &graphql.Field{
+						Type: objects[fk.Table],
+						Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+							value := p.Source.(map[string]interface{})[col]
+							if value == nil {
+								return nil, nil
+							}
+							rows, err := graphqlSelect(p.Context, fk.Table, squirrel.Eq{fk.Column: value}, map[string]interface{}{"limit": 1})
+							if err != nil || len(rows) == 0 {
+								return nil, err
+							}
+							return rows[0], nil
+						},
+					}
+				}
+
+				for _, ref := range references[table] {
+					ref := ref
+					name := ref.table
+					if _, taken := fields[name]; taken {
+						name = ref.table + "_by_" + ref.column
+					}
+					fields[name] = &graphql.Field{
+						Type: graphql.NewList(objects[ref.table]),
+						Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+							value := p.Source.(map[string]interface{})[ref.fk.Column]
+							return graphqlSelect(p.Context, ref.table, squirrel.Eq{ref.column: value}, nil)
+						},
+					}
+				}
+				return fields
+			}),
+		})
+	}
+
+	query := graphql.Fields{}
+	mutation := graphql.Fields{}
+	for _, table := range tables {
+		table, schema := table, schemas[table]
+
+		filterArgs := func() graphql.FieldConfigArgument {
+			args := graphql.FieldConfigArgument{}
+			for _, col := range schema.names {
+				if graphqlName.MatchString(col) && !graphqlArgs[col] {
+					args[col] = &graphql.ArgumentConfig{Type: graphqlScalar(schema.kinds[col])}
+				}
+			}
+			return args
+		}
+
+		readArgs := filterArgs()
+		readArgs["limit"] = &graphql.ArgumentConfig{Type: graphql.Int}
+		readArgs["offset"] = &graphql.ArgumentConfig{Type: graphql.Int}
+		readArgs["order_by"] = &graphql.ArgumentConfig{Type: graphql.String}
+		query[table] = &graphql.Field{
+			Type: graphql.NewList(objects[table]),
+			Args: readArgs,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return graphqlSelect(p.Context, table, graphqlFilter(p.Args), p.Args)
+			},
+		}
+
+		mutation["insert_"+table] = &graphql.Field{
+			Type: objects[table],
+			Args: graphql.FieldConfigArgument{
+				"values": &graphql.ArgumentConfig{Type: graphql.NewNonNull(inputs[table])},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				r := p.Context.Value(requestKey{}).(*http.Request)
+				values, _ := p.Args["values"].(map[string]interface{})
+				if len(values) == 0 {
+					return nil, errors.New("values needs at least one column")
+				}
+				data, sqldErr := withTx(r, func(ext sqlx.Ext) (interface{}, *SqldError) {
+					saved, err := createSingle(ext, table, values)
+					if err != nil {
+						return nil, BadRequest(err)
+					}
+					return saved, nil
+				})
+				if sqldErr != nil {
+					return nil, sqldErr
+				}
+				return data, nil
+			},
+		}
+
+		updateArgs := filterArgs()
+		updateArgs["set"] = &graphql.ArgumentConfig{Type: graphql.NewNonNull(inputs[table])}
+		mutation["update_"+table] = &graphql.Field{
+			Type: graphql.Int,
+			Args: updateArgs,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				set, _ := p.Args["set"].(map[string]interface{})
+				if len(set) == 0 {
+					return nil, errors.New("set needs at least one column")
+				}
+				return graphqlWrite(p.Context, table, graphqlFilter(p.Args), set, func() squirrel.UpdateBuilder {
+					query := sq.Update(table).SetMap(set)
+					if _, ok := set[*versionColumn]; versioned(table) && !ok {
+						query = query.Set(*versionColumn, squirrel.Expr(*versionColumn+" + 1"))
+					}
+					if col := softDeleteColumn(table); col != "" {
+						query = query.Where(squirrel.Eq{col: nil})
+					}
+					return query
+				})
+			},
+		}
+
+		mutation["delete_"+table] = &graphql.Field{
+			Type: graphql.Int,
+			Args: filterArgs(),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				col := softDeleteColumn(table)
+				if col == "" {
+					return graphqlWrite(p.Context, table, graphqlFilter(p.Args), nil, nil)
+				}
+				return graphqlWrite(p.Context, table, graphqlFilter(p.Args), nil, func() squirrel.UpdateBuilder {
+					return sq.Update(table).Set(col, time.Now().UTC()).Where(squirrel.Eq{col: nil})
+				})
+			},
+		}
+	}
+
+	config := graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: query}),
+	}
+	if len(mutation) > 0 {
+		config.Mutation = graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: mutation})
+	}
+	schema, err := graphql.NewSchema(config)
+	if err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// loadGraphQLSchema returns the GraphQL schema, generating it the first
+// time it is needed.
+func loadGraphQLSchema() (*graphql.Schema, error) {
+	graphqlOnce.Do(func() {
+		graphqlSchema, graphqlErr = buildGraphQLSchema()
+	})
+	return graphqlSchema, graphqlErr
+}
+
+// isMutation reports whether the operation a GraphQL query runs is a
+// mutation.
+func isMutation(query, operationName string) bool {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return false
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operationName == "" || op.Name != nil && op.Name.Value == operationName {
+			if op.Operation == ast.OperationTypeMutation {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleGraphQL handles requests to the GraphQL route:
+//
+//	GET  {url}_graphql?query=...   runs a query
+//	POST {url}_graphql             runs a query or mutation
+func handleGraphQL(r *http.Request) (interface{}, *SqldError) {
+	var req graphqlRequest
+	switch r.Method {
+	case "GET":
+		values := r.URL.Query()
+		req.Query = values.Get("query")
+		req.OperationName = values.Get("operationName")
+		if v := values.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				return nil, BadRequest(err)
+			}
+		}
+		if isMutation(req.Query, req.OperationName) {
+			return nil, NewError(errors.New("mutations must be sent with POST"), http.StatusMethodNotAllowed)
+		}
+	case "POST":
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, BadRequest(err)
+		}
+		defer r.Body.Close()
+		if err := decodeBody(r, body, &req); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, NewError(nil, http.StatusMethodNotAllowed)
+	}
+
+	if req.Query == "" {
+		return nil, BadRequest(errors.New("missing query"))
+	}
+	if withDeleted(r) && !privileged(r) {
+		return nil, Forbidden(errors.New("__with_deleted__ requires the admin token"))
+	}
+
+	schema, err := loadGraphQLSchema()
+	if err != nil {
+		return nil, InternalError(err)
+	}
+
+	return graphql.Do(graphql.Params{
+		Schema:         *schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        context.WithValue(r.Context(), requestKey{}, r),
+	}), nil
+}
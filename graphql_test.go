@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type graphqlResponse struct {
+	Data   map[string]interface{}   `json:"data"`
+	Errors []map[string]interface{} `json:"errors"`
+}
+
+func postGraphQL(handler http.Handler, query string) (int, graphqlResponse) {
+	body, _ := json.Marshal(graphqlRequest{Query: query})
+	req, _ := http.NewRequest("POST", "http://example.com/_graphql", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var res graphqlResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	return w.Code, res
+}
+
+func TestGraphQLOrderBy(t *testing.T) {
+	assert := assert.New(t)
+	schema := &tableSchema{kinds: map[string]string{"id": "int", "name": ""}}
+
+	order, err := graphqlOrderBy(schema, "name, id desc")
+	assert.Nil(err)
+	assert.Equal(order, []string{"name", "id DESC"})
+
+	_, err = graphqlOrderBy(schema, "nope")
+	assert.NotNil(err)
+
+	_, err = graphqlOrderBy(schema, "id; DROP TABLE t1")
+	assert.NotNil(err)
+}
+
+func TestGraphQL(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+	db.MustExec("CREATE TABLE authors(id INTEGER PRIMARY KEY, name TEXT)")
+	db.MustExec("CREATE TABLE books(id INTEGER PRIMARY KEY, title TEXT, author_id INTEGER REFERENCES authors(id))")
+	db.MustExec("INSERT INTO authors (id, name) VALUES (1, 'le guin'), (2, 'herbert')")
+	db.MustExec("INSERT INTO books (id, title, author_id) VALUES (1, 'earthsea', 1), (2, 'dune', 2), (3, 'the dispossessed', 1)")
+
+	code, res := postGraphQL(handler, `{ books(author_id: 1, order_by: "title") { title author { name } } }`)
+	assert.Equal(code, http.StatusOK)
+	assert.Empty(res.Errors)
+	assert.Equal(res.Data["books"], []interface{}{
+		map[string]interface{}{"title": "earthsea", "author": map[string]interface{}{"name": "le guin"}},
+		map[string]interface{}{"title": "the dispossessed", "author": map[string]interface{}{"name": "le guin"}},
+	})
+
+	_, res = postGraphQL(handler, `{ authors(name: "herbert") { books { title } } }`)
+	assert.Empty(res.Errors)
+	assert.Equal(res.Data["authors"], []interface{}{
+		map[string]interface{}{"books": []interface{}{map[string]interface{}{"title": "dune"}}},
+	})
+
+	_, res = postGraphQL(handler, `mutation { insert_books(values: {title: "children of dune", author_id: 2}) { id title } }`)
+	assert.Empty(res.Errors)
+	assert.Equal(res.Data["insert_books"], map[string]interface{}{"id": float64(4), "title": "children of dune"})
+
+	_, res = postGraphQL(handler, `mutation { update_books(id: 4, set: {title: "dune messiah"}) }`)
+	assert.Empty(res.Errors)
+	assert.Equal(res.Data["update_books"], float64(1))
+
+	_, res = postGraphQL(handler, `mutation { delete_books(author_id: 2) }`)
+	assert.Empty(res.Errors)
+	assert.Equal(res.Data["delete_books"], float64(2))
+
+	_, res = postGraphQL(handler, `mutation { delete_books }`)
+	assert.Len(res.Errors, 1)
+
+	_, res = postGraphQL(handler, `{ books(order_by: "nope") { id } }`)
+	assert.Len(res.Errors, 1)
+
+	req, _ := http.NewRequest("GET", "http://example.com/_graphql?query="+neturl.QueryEscape(`{ authors(id: 2) { name } }`), nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	assert.Equal(w.Body.String(), `{"data":{"authors":[{"name":"herbert"}]}}`+"\n")
+
+	req, _ = http.NewRequest("GET", "http://example.com/_graphql?query="+neturl.QueryEscape(`mutation { delete_books(id: 1) }`), nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusMethodNotAllowed)
+}
+
+func TestGraphQLWithDeleted(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+	db.MustExec("CREATE TABLE notes(id INTEGER PRIMARY KEY, body TEXT, deleted_at DATETIME)")
+	db.MustExec("INSERT INTO notes (id, body, deleted_at) VALUES (1, 'kept', NULL), (2, 'gone', '2020-01-01')")
+
+	*softDelete = "notes"
+	*adminToken = "secret"
+	defer func() { *softDelete, *adminToken = "", "" }()
+
+	query := neturl.QueryEscape(`{ notes { body } }`)
+	req, _ := http.NewRequest("GET", "http://example.com/_graphql?query="+query, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Body.String(), `{"data":{"notes":[{"body":"kept"}]}}`+"\n")
+
+	req, _ = http.NewRequest("GET", "http://example.com/_graphql?__with_deleted__=true&query="+query, nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusForbidden)
+
+	req, _ = http.NewRequest("GET", "http://example.com/_graphql?__with_deleted__=true&query="+query, nil)
+	req.Header.Set(adminHeader, "secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	assert.Equal(w.Body.String(), `{"data":{"notes":[{"body":"kept"},{"body":"gone"}]}}`+"\n")
+}
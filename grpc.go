@@ -0,0 +1,429 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative sqldpb/sqld.proto
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mmaelzer/sqld/sqldpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// grpcCodes maps the status codes of SqldErrors to gRPC codes.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:           codes.InvalidArgument,
+	http.StatusForbidden:            codes.PermissionDenied,
+	http.StatusNotFound:             codes.NotFound,
+	http.StatusMethodNotAllowed:     codes.Unimplemented,
+	http.StatusConflict:             codes.Aborted,
+	http.StatusPreconditionFailed:   codes.FailedPrecondition,
+	http.StatusUnsupportedMediaType: codes.InvalidArgument,
+	http.StatusInternalServerError:  codes.Internal,
+}
+
+// sqldServer implements the gRPC service. Each call is turned into the
+// http request the HTTP API would receive for it, so both APIs share the
+// same filters, soft deletes, transactions, and write guards.
+type sqldServer struct {
+	sqldpb.UnimplementedSqldServer
+}
+
+// newGRPCServer returns a gRPC server serving the sqld service and
+// logging each call.
+func newGRPCServer() *grpc.Server {
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			start := time.Now()
+			res, err := handler(ctx, req)
+			log.Printf("%s %s %s", status.Code(err), info.FullMethod, time.Since(start))
+			return res, err
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			start := time.Now()
+			err := handler(srv, ss)
+			log.Printf("%s %s %s", status.Code(err), info.FullMethod, time.Since(start))
+			return err
+		}),
+	)
+	sqldpb.RegisterSqldServer(s, &sqldServer{})
+	return s
+}
+
+// grpcError converts a SqldError to a gRPC status error.
+func grpcError(err *SqldError) error {
+	code, ok := grpcCodes[err.Code]
+	if !ok {
+		code = codes.Unknown
+	}
+	msg := err.Error()
+	if msg == "" {
+		msg = http.StatusText(err.Code)
+	}
+	return status.Error(code, msg)
+}
+
+// grpcRequest builds the http request the HTTP API would receive for a
+// call. Incoming metadata is copied into the request headers.
+func grpcRequest(ctx context.Context, method, path string, query neturl.Values, body []byte) *http.Request {
+	r := (&http.Request{
+		Method: method,
+		URL:    &neturl.URL{Path: path, RawQuery: query.Encode()},
+		Header: make(http.Header),
+		Body:   http.NoBody,
+	}).WithContext(ctx)
+
+	// Responses are always protobuf values, so an Accept header asking
+	// for another format does not apply
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		if strings.HasPrefix(key, ":") || key == "accept" {
+			continue
+		}
+		for _, v := range values {
+			r.Header.Add(key, v)
+		}
+	}
+
+	r.Header.Set("Content-Type", "application/json")
+	if body != nil {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	return r
+}
+
+// tablePath returns the url path of a table, or of one of its rows.
+func tablePath(table, id string) (string, *SqldError) {
+	if table == "" || strings.Contains(table, "/") {
+		return "", BadRequest(fmt.Errorf("invalid table %q", table))
+	}
+	path := *url + table
+	if id != "" {
+		path += "/" + id
+	}
+	return path, nil
+}
+
+// filterValues converts the filters of a call into query string
+// parameters. Lists match any of their values.
+func filterValues(filters map[string]*structpb.Value) (neturl.Values, *SqldError) {
+	query := neturl.Values{}
+	for key, val := range filters {
+		if strings.HasPrefix(key, "__") {
+			return nil, BadRequest(fmt.Errorf("filter %s is reserved", key))
+		}
+		if list := val.GetListValue(); list != nil {
+			for _, v := range list.Values {
+				query.Add(key, filterString(v))
+			}
+		} else {
+			query.Add(key, filterString(val))
+		}
+	}
+	return query, nil
+}
+
+// filterString formats a filter value the way it would be written in a
+// query string.
+func filterString(v *structpb.Value) string {
+	switch kind := v.Kind.(type) {
+	case *structpb.Value_StringValue:
+		return kind.StringValue
+	case *structpb.Value_NumberValue:
+		return strconv.FormatFloat(kind.NumberValue, 'f', -1, 64)
+	case *structpb.Value_BoolValue:
+		return strconv.FormatBool(kind.BoolValue)
+	case *structpb.Value_NullValue:
+		return ""
+	}
+	b, _ := json.Marshal(v.AsInterface())
+	return string(b)
+}
+
+// grpcValue converts a response of the HTTP API into a protobuf value.
+func grpcValue(v interface{}) (*structpb.Value, error) {
+	switch val := v.(type) {
+	case []map[string]interface{}:
+		list := make([]interface{}, len(val))
+		for i, row := range val {
+			list[i] = row
+		}
+		return grpcValue(list)
+	case []interface{}:
+		values := make([]*structpb.Value, len(val))
+		for i, item := range val {
+			value, err := grpcValue(item)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return structpb.NewListValue(&structpb.ListValue{Values: values}), nil
+	case map[string]interface{}:
+		s, err := grpcStruct(val)
+		if err != nil {
+			return nil, err
+		}
+		return structpb.NewStructValue(s), nil
+	}
+	return structpb.NewValue(v)
+}
+
+// grpcStruct converts a row into a protobuf struct.
+func grpcStruct(row map[string]interface{}) (*structpb.Struct, error) {
+	s := &structpb.Struct{Fields: make(map[string]*structpb.Value, len(row))}
+	for col, v := range row {
+		value, err := grpcValue(v)
+		if err != nil {
+			return nil, err
+		}
+		s.Fields[col] = value
+	}
+	return s, nil
+}
+
+// rawArg converts a raw query argument into the value a JSON request body
+// would have decoded to, so integers are bound as integers.
+func rawArg(v *structpb.Value) interface{} {
+	if n, ok := v.Kind.(*structpb.Value_NumberValue); ok {
+		return json.Number(strconv.FormatFloat(n.NumberValue, 'f', -1, 64))
+	}
+	return v.AsInterface()
+}
+
+// rawQuery converts a raw request into the RawQuery an HTTP request body
+// would have decoded to.
+func rawQuery(req *sqldpb.RawRequest) RawQuery {
+	query := RawQuery{
+		ReadQuery:  req.Read,
+		WriteQuery: req.Write,
+		Hash:       req.Hash,
+	}
+	for _, arg := range req.Args {
+		query.Args = append(query.Args, rawArg(arg))
+	}
+	if len(req.Params.GetFields()) > 0 {
+		query.Params = make(map[string]interface{}, len(req.Params.Fields))
+		for name, v := range req.Params.Fields {
+			query.Params[name] = rawArg(v)
+		}
+	}
+	for _, statement := range req.Statements {
+		query.Statements = append(query.Statements, rawQuery(statement))
+	}
+	return query
+}
+
+// Query streams the rows matching a request, built with buildSelectQuery
+// like a GET request.
+func (s *sqldServer) Query(req *sqldpb.QueryRequest, stream sqldpb.Sqld_QueryServer) error {
+	path, sqldErr := tablePath(req.Table, req.Id)
+	if sqldErr != nil {
+		return grpcError(sqldErr)
+	}
+	query, sqldErr := filterValues(req.Filters)
+	if sqldErr != nil {
+		return grpcError(sqldErr)
+	}
+	if req.Limit > 0 {
+		query.Set("__limit__", strconv.FormatInt(req.Limit, 10))
+	}
+	if req.Offset > 0 {
+		query.Set("__offset__", strconv.FormatInt(req.Offset, 10))
+	}
+	for _, order := range req.OrderBy {
+		query.Add("__order_by__", order)
+	}
+	if req.WithDeleted {
+		query.Set("__with_deleted__", "true")
+	}
+
+	ctx := stream.Context()
+	r := grpcRequest(ctx, "GET", path, query, nil)
+	if withDeleted(r) && !privileged(r) {
+		return grpcError(Forbidden(errors.New("with_deleted requires the admin token")))
+	}
+
+	sql, args, err := buildSelectQuery(r)
+	if err != nil {
+		return grpcError(BadRequest(err))
+	}
+
+	ext, release, sqldErr := executor(r)
+	if sqldErr != nil {
+		return grpcError(sqldErr)
+	}
+	defer release()
+
+	rows, err := ext.(sqlx.QueryerContext).QueryContext(ctx, sql, args...)
+	if err != nil {
+		return grpcError(BadRequest(err))
+	}
+	defer rows.Close()
+
+	if err := sendRows(stream, rows); err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return grpcError(InternalError(err))
+	}
+	return nil
+}
+
+// sendRows sends each row of a result as its own message.
+func sendRows(stream sqldpb.Sqld_QueryServer, rows *sql.Rows) error {
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	kinds := columnKindsOf(columns)
+
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			row[col.Name()] = columnValue(kinds[i], values[i])
+		}
+		s, err := grpcStruct(row)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(s); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Insert adds a row like a POST request.
+func (s *sqldServer) Insert(ctx context.Context, req *sqldpb.InsertRequest) (*structpb.Struct, error) {
+	path, sqldErr := tablePath(req.Table, "")
+	if sqldErr != nil {
+		return nil, grpcError(sqldErr)
+	}
+	if len(req.Row.GetFields()) == 0 {
+		return nil, grpcError(BadRequest(errors.New("row needs at least one column")))
+	}
+
+	body, err := req.Row.MarshalJSON()
+	if err != nil {
+		return nil, grpcError(BadRequest(err))
+	}
+
+	data, sqldErr := create(grpcRequest(ctx, "POST", path, nil, body))
+	if sqldErr != nil {
+		return nil, grpcError(sqldErr)
+	}
+	row, err := grpcStruct(data.(map[string]interface{}))
+	if err != nil {
+		return nil, grpcError(InternalError(err))
+	}
+	return row, nil
+}
+
+// Update changes rows like a PUT request.
+func (s *sqldServer) Update(ctx context.Context, req *sqldpb.UpdateRequest) (*sqldpb.WriteResult, error) {
+	if len(req.Values.GetFields()) == 0 {
+		return nil, grpcError(BadRequest(errors.New("values needs at least one column")))
+	}
+
+	r, sqldErr := writeRequest(ctx, "PUT", req.Table, req.Id, req.Filters, req.Limit, req.All)
+	if sqldErr != nil {
+		return nil, grpcError(sqldErr)
+	}
+
+	values := req.Values.AsMap()
+	sql, args, err := buildUpdateQuery(r, values)
+	if err != nil {
+		return nil, grpcError(BadRequest(err))
+	}
+	return writeResult(r, sql, args, requestWrite(r, values))
+}
+
+// Delete removes rows like a DELETE request.
+func (s *sqldServer) Delete(ctx context.Context, req *sqldpb.DeleteRequest) (*sqldpb.WriteResult, error) {
+	r, sqldErr := writeRequest(ctx, "DELETE", req.Table, req.Id, req.Filters, req.Limit, req.All)
+	if sqldErr != nil {
+		return nil, grpcError(sqldErr)
+	}
+
+	sql, args, err := buildDeleteQuery(r)
+	if err != nil {
+		return nil, grpcError(BadRequest(err))
+	}
+	return writeResult(r, sql, args, requestWrite(r, nil))
+}
+
+// writeRequest builds the request of an update or delete, refusing to
+// change every row unless asked to.
+func writeRequest(ctx context.Context, method, table, id string, filters map[string]*structpb.Value, limit int64, all bool) (*http.Request, *SqldError) {
+	path, err := tablePath(table, id)
+	if err != nil {
+		return nil, err
+	}
+	query, err := filterValues(filters)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 {
+		query.Set("__limit__", strconv.FormatInt(limit, 10))
+	}
+	if all {
+		query.Set("__all__", "true")
+	}
+
+	r := grpcRequest(ctx, method, path, query, nil)
+	if err := guardMassWrite(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// writeResult runs an update or delete and reports the rows it changed.
+func writeResult(r *http.Request, sql string, args []interface{}, w *rowWrite) (*sqldpb.WriteResult, error) {
+	rows, err := writeAffected(r, sql, args, w)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &sqldpb.WriteResult{RowsAffected: rows}, nil
+}
+
+// ExecRaw runs a raw query like a POST to the url prefix.
+func (s *sqldServer) ExecRaw(ctx context.Context, req *sqldpb.RawRequest) (*structpb.Value, error) {
+	if !*allowRaw {
+		return nil, grpcError(Forbidden(errors.New("raw queries are disabled, start sqld with -raw")))
+	}
+
+	data, sqldErr := execRaw(grpcRequest(ctx, "POST", *url, nil, nil), rawQuery(req))
+	if sqldErr != nil {
+		return nil, grpcError(sqldErr)
+	}
+	value, err := grpcValue(data)
+	if err != nil {
+		return nil, grpcError(InternalError(err))
+	}
+	return value, nil
+}
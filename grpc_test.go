@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+
+	"github.com/mmaelzer/sqld/sqldpb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func dialGRPC(t *testing.T) (sqldpb.SqldClient, func()) {
+	lis := bufconn.Listen(1 << 20)
+	s := newGRPCServer()
+	go s.Serve(lis)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sqldpb.NewSqldClient(conn), func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func queryRows(client sqldpb.SqldClient, req *sqldpb.QueryRequest) ([]map[string]interface{}, error) {
+	stream, err := client.Query(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]interface{}
+	for {
+		row, err := stream.Recv()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row.AsMap())
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+
+	createDB()
+	defer closeDB()
+	client, stop := dialGRPC(t)
+	defer stop()
+	ctx := context.Background()
+
+	rows, err := queryRows(client, &sqldpb.QueryRequest{
+		Table:   "t1",
+		Filters: map[string]*structpb.Value{"a": structpb.NewListValue(&structpb.ListValue{Values: []*structpb.Value{structpb.NewStringValue("hi"), structpb.NewStringValue("how")}})},
+		OrderBy: []string{"b"},
+	})
+	assert.Nil(err)
+	assert.Equal(rows, []map[string]interface{}{{"a": "how", "b": "dy"}, {"a": "hi", "b": "there"}})
+
+	rows, err = queryRows(client, &sqldpb.QueryRequest{Table: "t1", Limit: 1, Offset: 1, OrderBy: []string{"b"}})
+	assert.Nil(err)
+	assert.Equal(rows, []map[string]interface{}{{"a": "hi", "b": "there"}})
+
+	_, err = queryRows(client, &sqldpb.QueryRequest{Table: "nope"})
+	assert.Equal(status.Code(err), codes.InvalidArgument)
+
+	row, _ := structpb.NewStruct(map[string]interface{}{"a": "new", "b": "row"})
+	saved, err := client.Insert(ctx, &sqldpb.InsertRequest{Table: "t1", Row: row})
+	assert.Nil(err)
+	assert.Equal(saved.AsMap()["b"], "row")
+	assert.Equal(saved.AsMap()["id"], float64(3))
+
+	values, _ := structpb.NewStruct(map[string]interface{}{"a": "changed"})
+	res, err := client.Update(ctx, &sqldpb.UpdateRequest{
+		Table:   "t1",
+		Filters: map[string]*structpb.Value{"b": structpb.NewStringValue("row")},
+		Values:  values,
+	})
+	assert.Nil(err)
+	assert.Equal(res.RowsAffected, int64(1))
+
+	_, err = client.Update(ctx, &sqldpb.UpdateRequest{Table: "t1", Values: values})
+	assert.Equal(status.Code(err), codes.InvalidArgument)
+
+	_, err = client.Update(ctx, &sqldpb.UpdateRequest{
+		Table:   "t1",
+		Filters: map[string]*structpb.Value{"b": structpb.NewStringValue("missing")},
+		Values:  values,
+	})
+	assert.Equal(status.Code(err), codes.NotFound)
+
+	_, err = client.Delete(ctx, &sqldpb.DeleteRequest{Table: "t1"})
+	assert.Equal(status.Code(err), codes.InvalidArgument)
+
+	_, err = client.Delete(ctx, &sqldpb.DeleteRequest{
+		Table:   "t1",
+		Filters: map[string]*structpb.Value{"__all__": structpb.NewStringValue("true")},
+	})
+	assert.Equal(status.Code(err), codes.InvalidArgument)
+
+	res, err = client.Delete(ctx, &sqldpb.DeleteRequest{
+		Table:   "t1",
+		Filters: map[string]*structpb.Value{"a": structpb.NewStringValue("changed")},
+	})
+	assert.Nil(err)
+	assert.Equal(res.RowsAffected, int64(1))
+
+	_, err = client.ExecRaw(ctx, &sqldpb.RawRequest{Read: "SELECT * FROM t1"})
+	assert.Equal(status.Code(err), codes.PermissionDenied)
+
+	*allowRaw = true
+	defer func() { *allowRaw = false }()
+	result, err := client.ExecRaw(ctx, &sqldpb.RawRequest{
+		Read: "SELECT b FROM t1 WHERE a = ?",
+		Args: []*structpb.Value{structpb.NewStringValue("hi")},
+	})
+	assert.Nil(err)
+	assert.Equal(result.AsInterface(), []interface{}{map[string]interface{}{"b": "there"}})
+
+	// An accept header must not turn the result into a stream that is never
+	// read, holding on to the connection
+	result, err = client.ExecRaw(metadata.AppendToOutgoingContext(ctx, "accept", "text/csv"), &sqldpb.RawRequest{
+		Read: "SELECT b FROM t1 WHERE a = 'hi'",
+	})
+	assert.Nil(err)
+	assert.Equal(result.AsInterface(), []interface{}{map[string]interface{}{"b": "there"}})
+	assert.Equal(db.Stats().InUse, 0)
+
+	result, err = client.ExecRaw(ctx, &sqldpb.RawRequest{
+		Write: "UPDATE t1 SET a = :a WHERE b = :b",
+		Params: &structpb.Struct{Fields: map[string]*structpb.Value{
+			"a": structpb.NewNumberValue(7),
+			"b": structpb.NewStringValue("dy"),
+		}},
+	})
+	assert.Nil(err)
+	assert.Equal(result.GetStructValue().AsMap()["rows_affected"], float64(1))
+
+	rows, err = queryRows(client, &sqldpb.QueryRequest{Table: "t1", Id: "", Filters: map[string]*structpb.Value{"b": structpb.NewStringValue("dy")}})
+	assert.Nil(err)
+	assert.Equal(rows, []map[string]interface{}{{"a": float64(7), "b": "dy"}})
+}
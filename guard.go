@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var errMassWrite = errors.New("refusing to change every row without an id or filter, pass __all__=true to do so")
+
+// allowAllRows reports whether a write may target every row of table,
+// either because the request passes __all__=true or because the table is
+// listed in the allow-all flag.
+func allowAllRows(r *http.Request, table string) bool {
+	if r.URL.Query().Get("__all__") == "true" {
+		return true
+	}
+	for _, t := range strings.Split(*allowAll, ",") {
+		if strings.TrimSpace(t) == table {
+			return true
+		}
+	}
+	return false
+}
+
+// guardMassWrite refuses updates and deletes that would change every row
+// of a table because they carry neither an id nor a filter.
+func guardMassWrite(r *http.Request) *SqldError {
+	table, args, id := parseRequest(r)
+	if id != "" {
+		return nil
+	}
+	for key := range args {
+		if !strings.HasPrefix(key, "__") || !strings.HasSuffix(key, "__") {
+			return nil
+		}
+	}
+	if allowAllRows(r, table) {
+		return nil
+	}
+	return BadRequest(errMassWrite)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuardMassWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	req, _ := http.NewRequest("DELETE", "http://example.com/user", nil)
+	assert.Equal(guardMassWrite(req).Code, http.StatusBadRequest)
+
+	req, _ = http.NewRequest("DELETE", "http://example.com/user?__limit__=10", nil)
+	assert.Equal(guardMassWrite(req).Code, http.StatusBadRequest)
+
+	req, _ = http.NewRequest("DELETE", "http://example.com/user?__all__=true", nil)
+	assert.Nil(guardMassWrite(req))
+
+	req, _ = http.NewRequest("DELETE", "http://example.com/user/8", nil)
+	assert.Nil(guardMassWrite(req))
+
+	req, _ = http.NewRequest("DELETE", "http://example.com/user?name=jill", nil)
+	assert.Nil(guardMassWrite(req))
+
+	*allowAll = "events, user"
+	defer func() { *allowAll = "" }()
+	req, _ = http.NewRequest("DELETE", "http://example.com/user", nil)
+	assert.Nil(guardMassWrite(req))
+}
+
+func TestMaxAffected(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+
+	req, _ := http.NewRequest("DELETE", "http://example.com/t1", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusBadRequest)
+
+	*maxAffected = 1
+	defer func() { *maxAffected = 0 }()
+
+	req, _ = http.NewRequest("DELETE", "http://example.com/t1?__all__=true", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusBadRequest)
+	assert.Contains(w.Body.String(), "exceeds the limit")
+
+	var count int
+	db.Get(&count, "SELECT COUNT(*) FROM t1")
+	assert.Equal(count, 2)
+
+	// a write refused inside a client's transaction is undone there too
+	req, _ = http.NewRequest("POST", "http://example.com/_tx", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var tx map[string]string
+	json.Unmarshal(w.Body.Bytes(), &tx)
+
+	req, _ = http.NewRequest("DELETE", "http://example.com/t1?__all__=true", nil)
+	req.Header.Set(txHeader, tx["id"])
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusBadRequest)
+
+	req, _ = http.NewRequest("POST", "http://example.com/_tx/"+tx["id"]+"/commit", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusNoContent)
+	db.Get(&count, "SELECT COUNT(*) FROM t1")
+	assert.Equal(count, 2)
+
+	req, _ = http.NewRequest("DELETE", "http://example.com/t1?a=hi", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusNoContent)
+}
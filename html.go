@@ -0,0 +1,181 @@
+package main
+
+import (
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+)
+
+// htmlPageSize is the number of rows shown per page when a browsed table
+// has no __limit__.
+const htmlPageSize = 50
+
+//go:embed templates/table.html
+var templateFiles embed.FS
+
+var tableTemplate = template.Must(template.ParseFS(templateFiles, "templates/table.html"))
+
+// htmlColumn is a column header of the HTML table view.
+type htmlColumn struct {
+	Name    string
+	Filter  string
+	Sort    string
+	SortURL string
+}
+
+// htmlCell is a value of the HTML table view. Values of foreign key
+// columns link to the row they reference.
+type htmlCell struct {
+	Value string
+	Null  bool
+	Link  string
+}
+
+// htmlPage is a page of the HTML table view.
+type htmlPage struct {
+	Table   string
+	Action  string
+	OrderBy string
+	Limit   int
+	Offset  int
+	Columns []htmlColumn
+	Rows    [][]htmlCell
+	Prev    string
+	Next    string
+}
+
+// wantsHTML reports whether a request prefers an HTML page to JSON, as
+// browsers do, or asks for one with __format__=html.
+func wantsHTML(r *http.Request) bool {
+	if format := r.URL.Query().Get("__format__"); format != "" {
+		return format == "html"
+	}
+	return negotiate(r, []string{"application/json", "text/html"}) == "text/html"
+}
+
+// pageURL returns the url of the current page with its query changed.
+func pageURL(r *http.Request, set map[string]string) string {
+	values := r.URL.Query()
+	for key, val := range set {
+		if val == "" {
+			values.Del(key)
+		} else {
+			values.Set(key, val)
+		}
+	}
+	u := *r.URL
+	u.RawQuery = values.Encode()
+	return u.RequestURI()
+}
+
+// htmlValue formats a value from columnValue for display.
+func htmlValue(v interface{}) string {
+	if b, ok := v.([]byte); ok {
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return fmt.Sprint(v)
+}
+
+// readHTML reads a page of a table for the HTML table view. Pages hold
+// htmlPageSize rows unless the request sets a __limit__. Parameters asking
+// for a streamed, formatted, or explained result are dropped so the page
+// is always read as rows.
+func readHTML(r *http.Request) (interface{}, *SqldError) {
+	values := r.URL.Query()
+	values.Del("__format__")
+	values.Del("__stream__")
+	values.Del("__explain__")
+	if values.Get("__limit__") == "" {
+		values.Set("__limit__", strconv.Itoa(htmlPageSize))
+	}
+	u := *r.URL
+	u.RawQuery = values.Encode()
+	page := *r
+	page.URL = &u
+	page.Header = r.Header.Clone()
+	page.Header.Del("Accept")
+	r = &page
+
+	data, sqldErr := read(r)
+	if sqldErr != nil {
+		return nil, sqldErr
+	}
+	rows, _ := data.([]map[string]interface{})
+
+	table, _, _ := parseRequest(r)
+	args := r.URL.Query()
+	columns, err := tableColumns(table)
+	if err != nil {
+		return nil, InternalError(err)
+	}
+	fks, err := foreignKeys(table)
+	if err != nil {
+		return nil, InternalError(err)
+	}
+
+	result := &htmlPage{
+		Table:   table,
+		Action:  r.URL.Path,
+		OrderBy: args.Get("__order_by__"),
+		Columns: make([]htmlColumn, len(columns)),
+		Rows:    make([][]htmlCell, len(rows)),
+	}
+	result.Limit, _ = strconv.Atoi(args.Get("__limit__"))
+	result.Offset, _ = strconv.Atoi(args.Get("__offset__"))
+
+	order := strings.Fields(result.OrderBy)
+	for i, name := range columns {
+		col := htmlColumn{Name: name, Filter: args.Get(name)}
+		next := name
+		if len(order) > 0 && order[0] == name {
+			col.Sort = "asc"
+			if len(order) > 1 && strings.EqualFold(order[1], "DESC") {
+				col.Sort = "desc"
+			} else {
+				next = name + " DESC"
+			}
+		}
+		col.SortURL = pageURL(r, map[string]string{"__order_by__": next, "__offset__": ""})
+		result.Columns[i] = col
+	}
+
+	for i, row := range rows {
+		cells := make([]htmlCell, len(columns))
+		for j, name := range columns {
+			v := row[name]
+			if v == nil {
+				cells[j] = htmlCell{Value: "NULL", Null: true}
+				continue
+			}
+			cells[j] = htmlCell{Value: htmlValue(v)}
+			if fk, ok := fks[name]; ok {
+				cells[j].Link = *url + fk.Table + "?" + neturl.Values{fk.Column: {cells[j].Value}}.Encode()
+			}
+		}
+		result.Rows[i] = cells
+	}
+
+	if result.Offset > 0 {
+		prev := result.Offset - result.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		result.Prev = pageURL(r, map[string]string{"__offset__": strconv.Itoa(prev)})
+	}
+	if next, ok := nextPage(r, len(rows)).(string); ok {
+		result.Next = next
+	}
+	return result, nil
+}
+
+// writeHTML renders a page of the HTML table view.
+func writeHTML(w http.ResponseWriter, page *htmlPage) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	return tableTemplate.Execute(w, page)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWantsHTML(t *testing.T) {
+	assert := assert.New(t)
+
+	req, _ := http.NewRequest("GET", "http://example.com/t1", nil)
+	assert.False(wantsHTML(req))
+
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	assert.True(wantsHTML(req))
+
+	req.Header.Set("Accept", "*/*")
+	assert.False(wantsHTML(req))
+
+	req, _ = http.NewRequest("GET", "http://example.com/t1?__format__=html", nil)
+	assert.True(wantsHTML(req))
+}
+
+func TestReadHTML(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+	db.MustExec("CREATE TABLE authors(id INTEGER PRIMARY KEY, name TEXT)")
+	db.MustExec("CREATE TABLE books(id INTEGER PRIMARY KEY, title TEXT, author_id INTEGER REFERENCES authors(id))")
+	db.MustExec("INSERT INTO authors VALUES (1, 'Le Guin')")
+	db.MustExec("INSERT INTO books VALUES (1, 'The Dispossessed', 1), (2, '<Untitled>', NULL), (3, 'Lathe of Heaven', 1)")
+
+	req, _ := http.NewRequest("GET", "http://example.com/books?__limit__=2&__order_by__=title", nil)
+	req.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	assert.Equal(w.Header().Get("Content-Type"), "text/html; charset=utf-8")
+
+	body := w.Body.String()
+	assert.Contains(body, "<h1>books</h1>")
+	assert.Contains(body, "&lt;Untitled&gt;")
+	assert.Contains(body, `<td class="null">NULL</td>`)
+	assert.Contains(body, `<a href="/authors?id=1">1</a>`)
+	assert.Contains(body, `title &#9650;`)
+	assert.Contains(body, `href="/books?__limit__=2&amp;__order_by__=title&#43;DESC"`)
+	assert.Contains(body, `href="/books?__limit__=2&amp;__offset__=2&amp;__order_by__=title">Next`)
+	assert.NotContains(body, "Previous")
+	assert.NotContains(body, "The Dispossessed")
+
+	page, err := readHTML(req)
+	assert.Nil(err)
+	assert.Len(page.(*htmlPage).Rows, 2)
+
+	req, _ = http.NewRequest("GET", "http://example.com/books?__format__=html&author_id=1", nil)
+	page, err = readHTML(req)
+	assert.Nil(err)
+	assert.Equal(page.(*htmlPage).Limit, htmlPageSize)
+	assert.Len(page.(*htmlPage).Rows, 2)
+	assert.Equal(page.(*htmlPage).Columns[2].Filter, "1")
+	assert.Equal(page.(*htmlPage).Next, "")
+
+	// Streamed and explained results are never asked of read
+	for _, param := range []string{"__stream__=true", "__format__=ndjson", "__explain__=true"} {
+		req, _ = http.NewRequest("GET", "http://example.com/books?"+param, nil)
+		req.Header.Set("Accept", "text/html")
+		page, err = readHTML(req)
+		assert.Nil(err, param)
+		assert.Len(page.(*htmlPage).Rows, 3, param)
+	}
+
+	req, _ = http.NewRequest("GET", "http://example.com/books?__format__=html&__limit__=2&__offset__=2", nil)
+	page, err = readHTML(req)
+	assert.Nil(err)
+	assert.Equal(page.(*htmlPage).Prev, "/books?__limit__=2&__offset__=0")
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// idempotencyHeader is the request header that makes a POST safe to
+	// retry
+	idempotencyHeader = "Idempotency-Key"
+
+	// idempotencyTable is the sqld-managed table remembering the responses
+	// of POST requests sent with an idempotency key
+	idempotencyTable = "sqld_idempotency"
+
+	idempotencyDDL = `CREATE TABLE IF NOT EXISTS ` + idempotencyTable + ` (
+	idempotency_key VARCHAR(255) NOT NULL PRIMARY KEY,
+	fingerprint VARCHAR(64) NOT NULL,
+	response TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+)`
+)
+
+// idempotencySweep is the longest time between removals of expired
+// idempotency keys.
+const idempotencySweep = time.Hour
+
+var (
+	idempotencyOnce sync.Once
+	idempotencyErr  error
+)
+
+// idempotencyRecord is a stored idempotency key. An empty response means
+// the request that claimed the key has not finished yet.
+type idempotencyRecord struct {
+	Fingerprint string `db:"fingerprint"`
+	Response    string `db:"response"`
+}
+
+// ensureIdempotencyTable creates the idempotency table the first time it
+// is needed and starts removing keys older than -idempotency-ttl.
+func ensureIdempotencyTable() error {
+	idempotencyOnce.Do(func() {
+		if _, idempotencyErr = db.Exec(idempotencyDDL); idempotencyErr != nil {
+			return
+		}
+		if *idempotencyTTL > 0 {
+			go sweepIdempotencyKeys(*idempotencyTTL)
+		}
+	})
+	return idempotencyErr
+}
+
+// sweepIdempotencyKeys periodically removes idempotency keys older than
+// ttl.
+func sweepIdempotencyKeys(ttl time.Duration) {
+	interval := idempotencySweep
+	if ttl < interval {
+		interval = ttl
+	}
+	for range time.Tick(interval) {
+		if _, err := expireIdempotencyKeys(ttl); err != nil {
+			log.Printf("Unable to expire idempotency keys: %s\n", err)
+		}
+	}
+}
+
+// expireIdempotencyKeys removes idempotency keys older than ttl, after
+// which a repeated request creates a new row.
+func expireIdempotencyKeys(ttl time.Duration) (int64, error) {
+	query, args, err := sq.Delete(idempotencyTable).
+		Where(squirrel.Lt{"created_at": time.Now().UTC().Add(-ttl)}).
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// fingerprint identifies a request by its method, url and body so that a
+// key reused for a different request can be told apart from a retry.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func findIdempotencyKey(q sqlx.Queryer, key string) (*idempotencyRecord, error) {
+	query, args, err := sq.Select("fingerprint", "response").
+		From(idempotencyTable).
+		Where(squirrel.Eq{"idempotency_key": key}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var rec idempotencyRecord
+	err = sqlx.Get(q, &rec, query, args...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// replay returns the stored response for a repeated idempotency key.
+func replay(rec *idempotencyRecord, fp string) (interface{}, *SqldError) {
+	if rec.Fingerprint != fp {
+		return nil, Conflict(errors.New("idempotency key was already used for a different request"))
+	}
+	if rec.Response == "" {
+		return nil, Conflict(errors.New("a request with this idempotency key is still in progress"))
+	}
+	return json.RawMessage(rec.Response), nil
+}
+
+// createIdempotent creates a single row at most once per idempotency key.
+// The key is claimed in the same transaction that creates the row, so a
+// failed create leaves the key free to retry.
+func createIdempotent(r *http.Request, key string, body []byte, table string, item map[string]interface{}) (interface{}, *SqldError) {
+	if err := ensureIdempotencyTable(); err != nil {
+		return nil, InternalError(err)
+	}
+
+	fp := fingerprint(r, body)
+	raced := false
+	data, sqldErr := withTx(r, func(ext sqlx.Ext) (interface{}, *SqldError) {
+		rec, err := findIdempotencyKey(ext, key)
+		if err != nil {
+			return nil, InternalError(err)
+		}
+		if rec != nil {
+			return replay(rec, fp)
+		}
+
+		claim, args, err := sq.Insert(idempotencyTable).
+			Columns("idempotency_key", "fingerprint", "response", "created_at").
+			Values(key, fp, "", time.Now().UTC()).
+			ToSql()
+		if err != nil {
+			return nil, InternalError(err)
+		}
+		if _, err := ext.Exec(claim, args...); err != nil {
+			// Another request claimed the key since we looked
+			raced = true
+			return nil, Conflict(err)
+		}
+
+		saved, err := createSingle(ext, table, item)
+		if err != nil {
+			return nil, InternalError(err)
+		}
+
+		response, err := json.Marshal(saved)
+		if err != nil {
+			return nil, InternalError(err)
+		}
+
+		store, args, err := sq.Update(idempotencyTable).
+			Set("response", string(response)).
+			Where(squirrel.Eq{"idempotency_key": key}).
+			ToSql()
+		if err != nil {
+			return nil, InternalError(err)
+		}
+		if _, err := ext.Exec(store, args...); err != nil {
+			return nil, InternalError(err)
+		}
+		return saved, nil
+	})
+
+	if raced {
+		rec, err := findIdempotencyKey(db, key)
+		if err == nil && rec != nil {
+			return replay(rec, fp)
+		}
+	}
+	return data, sqldErr
+}
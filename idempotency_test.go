@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+	assert := assert.New(t)
+
+	req, _ := http.NewRequest("POST", "http://example.com/t1", nil)
+	fp := fingerprint(req, []byte(`{"a": "b"}`))
+	assert.Len(fp, 64)
+	assert.Equal(fp, fingerprint(req, []byte(`{"a": "b"}`)))
+	assert.NotEqual(fp, fingerprint(req, []byte(`{"a": "c"}`)))
+}
+
+func TestCreateIdempotent(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "http://example.com/t1", bytes.NewBufferString(body))
+		req.Header.Set(idempotencyHeader, key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("abc", `{"a": "once", "b": "only"}`)
+	assert.Equal(w.Code, http.StatusCreated)
+	first := map[string]interface{}{}
+	json.Unmarshal(w.Body.Bytes(), &first)
+	assert.Equal(first["a"], "once")
+
+	w = post("abc", `{"a": "once", "b": "only"}`)
+	assert.Equal(w.Code, http.StatusCreated)
+	second := map[string]interface{}{}
+	json.Unmarshal(w.Body.Bytes(), &second)
+	assert.Equal(first, second)
+
+	var count int
+	db.Get(&count, "SELECT COUNT(*) FROM t1 WHERE a = 'once'")
+	assert.Equal(count, 1)
+
+	w = post("abc", `{"a": "twice", "b": "only"}`)
+	assert.Equal(w.Code, http.StatusConflict)
+
+	w = post("def", `{"nope": "column"}`)
+	assert.Equal(w.Code, http.StatusInternalServerError)
+
+	w = post("def", `{"a": "retried", "b": "fine"}`)
+	assert.Equal(w.Code, http.StatusCreated)
+}
+
+func TestExpireIdempotencyKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	createDB()
+	defer closeDB()
+
+	assert.Nil(ensureIdempotencyTable())
+	insert := "INSERT INTO " + idempotencyTable + " (idempotency_key, fingerprint, response, created_at) VALUES (?, 'fp', '{}', ?)"
+	db.MustExec(insert, "old", time.Now().UTC().Add(-2*time.Hour))
+	db.MustExec(insert, "new", time.Now().UTC())
+
+	removed, err := expireIdempotencyKeys(time.Hour)
+	assert.Nil(err)
+	assert.Equal(removed, int64(1))
+
+	var keys []string
+	db.Select(&keys, "SELECT idempotency_key FROM "+idempotencyTable)
+	assert.Equal(keys, []string{"new"})
+}
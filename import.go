@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// importBatchRows is the most rows inserted by one statement during an
+	// import
+	importBatchRows = 500
+
+	// importMaxArgs keeps the arguments of a batch insert under the limit
+	// of the databases, SQLite being the lowest
+	importMaxArgs = 999
+
+	// importMaxErrors is the most line errors listed in an import summary
+	importMaxErrors = 100
+)
+
+// importError is a line of an import that could not be inserted.
+type importError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// importSummary is the response to an import.
+type importSummary struct {
+	Inserted int           `json:"inserted"`
+	Failed   int           `json:"failed"`
+	Errors   []importError `json:"errors"`
+}
+
+func (s *importSummary) fail(line int, err error) {
+	s.Failed++
+	if len(s.Errors) < importMaxErrors {
+		s.Errors = append(s.Errors, importError{line, err.Error()})
+	}
+}
+
+// importFormat returns the bulk import format named by a request's
+// Content-Type, or "" for requests creating a single row.
+func importFormat(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return "csv"
+	case "application/x-ndjson":
+		return "ndjson"
+	}
+	return ""
+}
+
+// importBatch collects rows with the same columns for one insert.
+type importBatch struct {
+	ext     sqlx.Ext
+	table   string
+	columns []string
+	rows    [][]interface{}
+	lines   []int
+	summary *importSummary
+}
+
+// add queues a row read from line, first inserting the queued rows if
+// the row's columns differ or the batch is full.
+func (b *importBatch) add(line int, columns []string, row []interface{}) *SqldError {
+	if len(b.rows) > 0 && !sameColumns(b.columns, columns) {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+	if len(b.rows) == 0 {
+		b.columns = columns
+	}
+	b.rows = append(b.rows, row)
+	b.lines = append(b.lines, line)
+
+	limit := importMaxArgs / len(columns)
+	if limit > importBatchRows {
+		limit = importBatchRows
+	}
+	if len(b.rows) >= limit {
+		return b.flush()
+	}
+	return nil
+}
+
+// flush inserts the queued rows. When the database refuses the batch, its
+// rows are inserted one at a time so that only the lines it refuses are
+// skipped and reported.
+func (b *importBatch) flush() *SqldError {
+	if len(b.rows) == 0 {
+		return nil
+	}
+	defer func() {
+		b.rows = b.rows[:0]
+		b.lines = b.lines[:0]
+	}()
+
+	sqldErr := b.insert(b.rows)
+	if sqldErr == nil {
+		b.summary.Inserted += len(b.rows)
+		return nil
+	}
+	if sqldErr.Code != http.StatusBadRequest {
+		return sqldErr
+	}
+
+	for i, row := range b.rows {
+		sqldErr := b.insert([][]interface{}{row})
+		if sqldErr != nil && sqldErr.Code != http.StatusBadRequest {
+			return sqldErr
+		}
+		if sqldErr != nil {
+			b.summary.fail(b.lines[i], sqldErr)
+			continue
+		}
+		b.summary.Inserted++
+	}
+	return nil
+}
+
+// insert inserts rows with one statement inside a savepoint, so a refused
+// insert leaves the transaction usable.
+func (b *importBatch) insert(rows [][]interface{}) *SqldError {
+	query := sq.Insert(b.table).Columns(b.columns...)
+	for _, row := range rows {
+		query = query.Values(row...)
+	}
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return InternalError(err)
+	}
+
+	_, sqldErr := withSavepoint(b.ext, importSavepoint, func(ext sqlx.Ext) (interface{}, *SqldError) {
+		if _, err := ext.Exec(sql, args...); err != nil {
+			return nil, BadRequest(err)
+		}
+		if recordingChanges() {
+			for _, row := range rows {
+				c := &change{Table: b.table, Op: "insert", Row: make(map[string]interface{}, len(row))}
+				for i, col := range b.columns {
+					c.Row[col] = row[i]
+				}
+				recordChange(ext, c)
+			}
+		}
+		return nil, nil
+	})
+	return sqldErr
+}
+
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// importRows inserts the rows of a CSV or NDJSON request body into a
+// table, in batches inside one transaction. Lines that cannot be read,
+// converted to their columns' types, or inserted are skipped and reported
+// in the summary. Imports cannot be made safe to retry with an idempotency
+// key, so the header is refused rather than ignored.
+func importRows(r *http.Request, table, format string) (interface{}, *SqldError) {
+	defer r.Body.Close()
+
+	if r.Header.Get(idempotencyHeader) != "" {
+		return nil, BadRequest(errors.New("imports do not support the " + idempotencyHeader + " header"))
+	}
+
+	schema, err := loadTableSchema(table)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	return withTx(r, func(ext sqlx.Ext) (interface{}, *SqldError) {
+		summary := &importSummary{Errors: []importError{}}
+		batch := &importBatch{ext: ext, table: table, summary: summary}
+
+		var sqldErr *SqldError
+		if format == "csv" {
+			sqldErr = importCSV(r.Body, schema, batch)
+		} else {
+			sqldErr = importNDJSON(r.Body, schema, batch)
+		}
+		if sqldErr != nil {
+			return nil, sqldErr
+		}
+		if sqldErr := batch.flush(); sqldErr != nil {
+			return nil, sqldErr
+		}
+		return summary, nil
+	})
+}
+
+// importCSV reads CSV rows whose first line names their columns. Empty
+// fields are NULL.
+func importCSV(body io.Reader, schema *tableSchema, batch *importBatch) *SqldError {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return BadRequest(fmt.Errorf("line 1: %s", err))
+	}
+	columns := make([]string, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if _, ok := schema.kinds[name]; !ok {
+			return BadRequest(fmt.Errorf("line 1: unknown column %s", name))
+		}
+		columns[i] = name
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if pe, ok := err.(*csv.ParseError); ok {
+			batch.summary.fail(pe.StartLine, pe.Err)
+			continue
+		}
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		if len(record) != len(columns) {
+			batch.summary.fail(line, fmt.Errorf("has %d fields, the header has %d", len(record), len(columns)))
+			continue
+		}
+
+		row, err := csvRow(schema, columns, record)
+		if err != nil {
+			batch.summary.fail(line, err)
+			continue
+		}
+		if sqldErr := batch.add(line, columns, row); sqldErr != nil {
+			return sqldErr
+		}
+	}
+}
+
+func csvRow(schema *tableSchema, columns, record []string) ([]interface{}, error) {
+	row := make([]interface{}, len(record))
+	for i, field := range record {
+		if field == "" {
+			continue
+		}
+		v, err := importValue(schema.kinds[columns[i]], field)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %s", columns[i], err)
+		}
+		row[i] = v
+	}
+	return row, nil
+}
+
+// importNDJSON reads one JSON object per line. Blank lines are skipped.
+func importNDJSON(body io.Reader, schema *tableSchema, batch *importBatch) *SqldError {
+	reader := bufio.NewReader(body)
+	for line := 1; ; line++ {
+		text, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return BadRequest(err)
+		}
+		if len(bytes.TrimSpace(text)) > 0 {
+			columns, row, rowErr := ndjsonRow(schema, text)
+			if rowErr != nil {
+				batch.summary.fail(line, rowErr)
+			} else if sqldErr := batch.add(line, columns, row); sqldErr != nil {
+				return sqldErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func ndjsonRow(schema *tableSchema, text []byte) ([]string, []interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(text))
+	decoder.UseNumber()
+	var item map[string]interface{}
+	if err := decoder.Decode(&item); err != nil {
+		return nil, nil, err
+	}
+	if len(item) == 0 {
+		return nil, nil, errors.New("no columns")
+	}
+
+	columns := make([]string, 0, len(item))
+	for name := range item {
+		if _, ok := schema.kinds[name]; !ok {
+			return nil, nil, fmt.Errorf("unknown column %s", name)
+		}
+		columns = append(columns, name)
+	}
+	sort.Strings(columns)
+
+	row := make([]interface{}, len(columns))
+	for i, name := range columns {
+		v := item[name]
+		if s, ok := v.(string); ok {
+			var err error
+			if v, err = importValue(schema.kinds[name], s); err != nil {
+				return nil, nil, fmt.Errorf("column %s: %s", name, err)
+			}
+		} else if n, ok := v.(json.Number); ok && schema.kinds[name] != "" {
+			var err error
+			if v, err = importValue(schema.kinds[name], n.String()); err != nil {
+				return nil, nil, fmt.Errorf("column %s: %s", name, err)
+			}
+		}
+		row[i] = bindValue(v)
+	}
+	return columns, row, nil
+}
+
+// importTimeLayouts are the timestamp layouts accepted by imports.
+var importTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// importValue converts a text value to the type of its column. Binary
+// columns take base64 and decimals are passed through as text to keep
+// their exact value.
+func importValue(kind, s string) (interface{}, error) {
+	switch kind {
+	case "int":
+		return strconv.ParseInt(s, 10, 64)
+	case "float":
+		return strconv.ParseFloat(s, 64)
+	case "bool":
+		return strconv.ParseBool(s)
+	case "binary":
+		return base64.StdEncoding.DecodeString(s)
+	case "time":
+		for _, layout := range importTimeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("cannot parse %q as a time", s)
+	}
+	return s, nil
+}
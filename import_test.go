@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportValue(t *testing.T) {
+	assert := assert.New(t)
+
+	v, err := importValue("int", "42")
+	assert.Nil(err)
+	assert.Equal(v, int64(42))
+
+	_, err = importValue("int", "4.2")
+	assert.NotNil(err)
+
+	v, err = importValue("binary", "AP8=")
+	assert.Nil(err)
+	assert.Equal(v, []byte{0, 255})
+
+	v, err = importValue("decimal", "10.10")
+	assert.Nil(err)
+	assert.Equal(v, "10.10")
+
+	_, err = importValue("time", "yesterday")
+	assert.NotNil(err)
+}
+
+func TestImport(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+	db.MustExec("CREATE TABLE imported(id INTEGER PRIMARY KEY, name TEXT, score REAL, seen DATETIME)")
+
+	body := bytes.NewBufferString("name,score,seen\r\n" +
+		"jim,1.5,2020-01-02 03:04:05\r\n" +
+		"\"jill, again\",2,\r\n" +
+		"bob,lots,\r\n" +
+		"sue\r\n")
+	req, _ := http.NewRequest("POST", "http://example.com/imported", body)
+	req.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusCreated)
+	var summary importSummary
+	json.Unmarshal(w.Body.Bytes(), &summary)
+	assert.Equal(summary.Inserted, 2)
+	assert.Equal(summary.Failed, 2)
+	assert.Equal(summary.Errors[0].Line, 4)
+	assert.Contains(summary.Errors[0].Error, "column score")
+	assert.Equal(summary.Errors[1].Line, 5)
+
+	body = bytes.NewBufferString(`{"name": "ann", "score": 3}
+{"name": "ned"}
+
+{"nope": 1}
+not json
+{"id": 1, "name": "dup"}
+`)
+	req, _ = http.NewRequest("POST", "http://example.com/imported", body)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusCreated)
+	summary = importSummary{}
+	json.Unmarshal(w.Body.Bytes(), &summary)
+	assert.Equal(summary.Inserted, 2)
+	assert.Equal(summary.Errors[0].Line, 4)
+	assert.Equal(summary.Errors[1].Line, 5)
+	assert.Equal(summary.Errors[2].Line, 6)
+	assert.Contains(summary.Errors[2].Error, "UNIQUE")
+
+	// Rows the database refuses are skipped, not the rest of their batch
+	body = bytes.NewBufferString("id,name\r\n10,ten\r\n1,dup\r\n11,eleven\r\n")
+	req, _ = http.NewRequest("POST", "http://example.com/imported", body)
+	req.Header.Set("Content-Type", "text/csv")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusCreated)
+	summary = importSummary{}
+	json.Unmarshal(w.Body.Bytes(), &summary)
+	assert.Equal(summary.Inserted, 2)
+	assert.Equal(summary.Failed, 1)
+	assert.Equal(summary.Errors[0].Line, 3)
+
+	var count int
+	db.Get(&count, "SELECT COUNT(*) FROM imported")
+	assert.Equal(count, 6)
+
+	var score float64
+	db.Get(&score, "SELECT score FROM imported WHERE name = 'ann'")
+	assert.Equal(score, 3.0)
+
+	req, _ = http.NewRequest("POST", "http://example.com/imported", bytes.NewBufferString("name,nope\r\n"))
+	req.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusBadRequest)
+
+	// A retried import would insert its rows again
+	req, _ = http.NewRequest("POST", "http://example.com/imported", bytes.NewBufferString("name\r\nkim\r\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	req.Header.Set(idempotencyHeader, "import-kim")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusBadRequest)
+	assert.Contains(w.Body.String(), idempotencyHeader)
+	db.Get(&count, "SELECT COUNT(*) FROM imported")
+	assert.Equal(count, 6)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+func init() {
+	rowFormats["parquet"] = &rowFormat{
+		mediaType:  "application/vnd.apache.parquet",
+		extension:  "parquet",
+		newEncoder: newParquetEncoder,
+	}
+}
+
+// parquetGroupRows is the number of rows written in each Parquet row
+// group. parquet-go otherwise holds rows until a row group reaches 128 MB.
+const parquetGroupRows = 10000
+
+// parquetSchema returns the parquet-go metadata of a column.
+func parquetSchema(name, kind string) string {
+	tag := "name=" + name + ", repetitiontype=OPTIONAL, "
+	switch kind {
+	case "int":
+		return tag + "type=INT64"
+	case "float":
+		return tag + "type=DOUBLE"
+	case "bool":
+		return tag + "type=BOOLEAN"
+	case "binary":
+		return tag + "type=BYTE_ARRAY"
+	case "time":
+		return tag + "type=INT64, convertedtype=TIMESTAMP_MICROS"
+	}
+	return tag + "type=BYTE_ARRAY, convertedtype=UTF8"
+}
+
+// parquetEncoder writes a Parquet file, a row group for every
+// parquetGroupRows rows. The file's footer is written once every row has
+// been read.
+type parquetEncoder struct {
+	w      io.Writer
+	kinds  []string
+	names  []string
+	writer *writer.CSVWriter
+	rows   int
+}
+
+func newParquetEncoder(w io.Writer, r *http.Request) rowEncoder {
+	return &parquetEncoder{w: w}
+}
+
+func (e *parquetEncoder) Begin(columns []*sql.ColumnType) error {
+	e.kinds = columnKindsOf(columns)
+	e.names = make([]string, len(columns))
+	schema := make([]string, len(columns))
+	for i, ct := range columns {
+		e.names[i] = ct.Name()
+		schema[i] = parquetSchema(ct.Name(), e.kinds[i])
+	}
+
+	var err error
+	e.writer, err = writer.NewCSVWriterFromWriter(schema, e.w, 1)
+	return err
+}
+
+func (e *parquetEncoder) Row(values []interface{}) error {
+	// The writer keeps each record until its row group is flushed, so
+	// every row needs its own slice
+	record := make([]interface{}, len(values))
+	for i, v := range values {
+		value, err := e.value(e.kinds[i], v)
+		if err != nil {
+			return fmt.Errorf("column %s: %s", e.names[i], err)
+		}
+		record[i] = value
+	}
+	if err := e.writer.Write(record); err != nil {
+		return err
+	}
+
+	e.rows++
+	if e.rows%parquetGroupRows == 0 {
+		return e.writer.Flush(true)
+	}
+	return nil
+}
+
+func (e *parquetEncoder) value(kind string, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	switch kind {
+	case "int":
+		return toInt64(v)
+	case "float":
+		return toFloat64(v)
+	case "bool":
+		return toBool(v)
+	case "binary":
+		if b, ok := v.([]byte); ok {
+			return string(b), nil
+		}
+		return fmt.Sprint(v), nil
+	case "time":
+		t, err := toTime(v)
+		if err != nil {
+			return nil, err
+		}
+		return t.UnixNano() / int64(time.Microsecond), nil
+	}
+	return csvValue(v), nil
+}
+
+func (e *parquetEncoder) End() error {
+	return e.writer.WriteStop()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParquetSchema(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(parquetSchema("id", "int"), "name=id, repetitiontype=OPTIONAL, type=INT64")
+	assert.Equal(parquetSchema("at", "time"), "name=at, repetitiontype=OPTIONAL, type=INT64, convertedtype=TIMESTAMP_MICROS")
+	assert.Equal(parquetSchema("price", "decimal"), "name=price, repetitiontype=OPTIONAL, type=BYTE_ARRAY, convertedtype=UTF8")
+}
+
+func TestParquet(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+
+	req, _ := http.NewRequest("GET", "http://example.com/t1?__format__=parquet", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	assert.Equal(w.Header().Get("Content-Type"), "application/vnd.apache.parquet")
+	assert.Equal(w.Header().Get("Content-Disposition"), "attachment; filename=t1.parquet")
+
+	body := w.Body.Bytes()
+	assert.True(len(body) > 8)
+	assert.Equal(string(body[:4]), "PAR1")
+	assert.Equal(string(body[len(body)-4:]), "PAR1")
+}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Statement kinds recognized by the raw policy
+const (
+	kindSelect = "SELECT"
+	kindInsert = "INSERT"
+	kindUpdate = "UPDATE"
+	kindDelete = "DELETE"
+	kindDDL    = "DDL"
+	kindOther  = "OTHER"
+)
+
+// rawPolicy restricts the statements raw queries may run. A nil policy
+// allows everything.
+var rawPolicy *RawPolicy
+
+// RawPolicy decides which statements raw queries may run, loaded from the
+// JSON file named by the raw-policy flag:
+//
+//	{
+//	  "default": "deny",
+//	  "rules": [
+//	    {"action": "allow", "statements": ["SELECT"], "tables": ["*"]},
+//	    {"action": "allow", "statements": ["INSERT"], "tables": ["events"]},
+//	    {"action": "deny", "statements": ["DDL"]}
+//	  ]
+//	}
+//
+// Every table a statement touches is checked against the rules in order
+// and the first matching rule decides. Tables no rule matches get the
+// default action.
+type RawPolicy struct {
+	Default string       `json:"default"`
+	Rules   []PolicyRule `json:"rules"`
+}
+
+// PolicyRule allows or denies statement kinds (SELECT, INSERT, UPDATE,
+// DELETE, DDL, OTHER or *) on tables. A rule without tables, or with "*",
+// matches every table.
+type PolicyRule struct {
+	Action     string   `json:"action"`
+	Statements []string `json:"statements"`
+	Tables     []string `json:"tables"`
+}
+
+// sqlStatement is a single SQL statement classified by kind, along with
+// the tables it touches.
+type sqlStatement struct {
+	kind   string
+	tables []string
+
+	// nested are the statements writing from inside this one, such as a
+	// DELETE in a WITH clause or the table a SELECT INTO creates
+	nested []sqlStatement
+}
+
+// sqlToken is a word, quoted identifier, or punctuation character of a
+// SQL statement. String literals are reduced to a single "'" token, and
+// pos is the byte offset the token starts at.
+type sqlToken struct {
+	text   string
+	quoted bool
+	pos    int
+}
+
+func (t sqlToken) is(keyword string) bool {
+	return !t.quoted && strings.EqualFold(t.text, keyword)
+}
+
+// sqlKeywords are words that end a table reference rather than alias it
+var sqlKeywords = map[string]bool{
+	"AS": true, "CROSS": true, "EXCEPT": true, "FOR": true, "FROM": true,
+	"FULL": true, "GROUP": true, "HAVING": true, "INNER": true, "INTERSECT": true,
+	"JOIN": true, "LATERAL": true, "LEFT": true, "LIMIT": true, "NATURAL": true,
+	"OFFSET": true, "ON": true, "ONLY": true, "ORDER": true, "OUTER": true,
+	"RETURNING": true, "RIGHT": true, "SELECT": true, "SET": true, "UNION": true,
+	"USING": true, "VALUES": true, "WHERE": true, "WINDOW": true, "DEFAULT": true,
+}
+
+func isKeyword(t sqlToken) bool {
+	return !t.quoted && sqlKeywords[strings.ToUpper(t.text)]
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c == '$' || c >= '0' && c <= '9'
+}
+
+// tokenizeSQL splits a SQL string into tokens, dropping comments. Strings,
+// quoted identifiers and comments are read the way the configured database
+// reads them, and input that cannot be split with certainty, such as an
+// unterminated string, is an error.
+func tokenizeSQL(s string) ([]sqlToken, error) {
+	mysql := *dbtype == "mysql"
+	postgres := *dbtype == "postgres"
+
+	var tokens []sqlToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '-' && strings.HasPrefix(s[i:], "--") && (!mysql || i+2 == len(s) || s[i+2] <= ' '),
+			c == '#' && mysql:
+			// MySQL only starts a -- comment when whitespace follows
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(s[i:], "/*"):
+			if mysql && strings.HasPrefix(s[i:], "/*!") {
+				return nil, errors.New("MySQL executable comments are not supported")
+			}
+			end, ok := commentEnd(s, i, postgres)
+			if !ok {
+				return nil, errors.New("unterminated comment")
+			}
+			i = end
+		case c == '\'', c == '"' && mysql:
+			// MySQL reads double quotes as strings and backslashes as escapes
+			// in them, as Postgres does in E'' strings
+			escaped := mysql || postgres && len(tokens) > 0 && !tokens[len(tokens)-1].quoted &&
+				strings.EqualFold(tokens[len(tokens)-1].text, "E") && i > 0 && isIdentPart(s[i-1])
+			end, ok := quoteEnd(s, i, escaped)
+			if !ok {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, sqlToken{text: "'", pos: i})
+			i = end
+		case c == '$' && postgres && dollarTag(s[i:]) != "":
+			tag := dollarTag(s[i:])
+			end := strings.Index(s[i+len(tag):], tag)
+			if end < 0 {
+				return nil, errors.New("unterminated dollar quoted string")
+			}
+			tokens = append(tokens, sqlToken{text: "'", pos: i})
+			i += len(tag) + end + len(tag)
+		case c == '[' && *dbtype == "sqlite3":
+			end := strings.IndexByte(s[i+1:], ']')
+			if end < 0 {
+				return nil, errors.New("unterminated quoted identifier")
+			}
+			tokens = append(tokens, sqlToken{text: s[i+1 : i+1+end], quoted: true, pos: i})
+			i += end + 2
+		case c == '"' || c == '`':
+			end, ok := quoteEnd(s, i, false)
+			if !ok {
+				return nil, errors.New("unterminated quoted identifier")
+			}
+			q := string(c)
+			tokens = append(tokens, sqlToken{text: strings.Replace(s[i+1:end-1], q+q, q, -1), quoted: true, pos: i})
+			i = end
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isIdentStart(c):
+			start := i
+			for i < len(s) && isIdentPart(s[i]) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{text: s[start:i], pos: start})
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(s) && (isIdentPart(s[i]) || s[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{text: s[start:i], pos: start})
+		default:
+			tokens = append(tokens, sqlToken{text: string(c), pos: i})
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// quoteEnd returns the index following the string or quoted identifier
+// starting at s[i]. A doubled quote, or with escaped set a backslash,
+// escapes the character after it. ok is false when the quote is never
+// closed.
+func quoteEnd(s string, i int, escaped bool) (end int, ok bool) {
+	q := s[i]
+	for j := i + 1; j < len(s); j++ {
+		switch {
+		case escaped && s[j] == '\\':
+			j++
+		case s[j] == q:
+			if j+1 < len(s) && s[j+1] == q {
+				j++
+				continue
+			}
+			return j + 1, true
+		}
+	}
+	return len(s), false
+}
+
+// commentEnd returns the index following the block comment starting at
+// s[i]. Postgres lets block comments nest.
+func commentEnd(s string, i int, nested bool) (end int, ok bool) {
+	depth := 0
+	for j := i; j+1 < len(s); j++ {
+		switch {
+		case s[j] == '/' && s[j+1] == '*' && (nested || depth == 0):
+			depth++
+			j++
+		case s[j] == '*' && s[j+1] == '/':
+			depth--
+			j++
+			if depth == 0 {
+				return j + 1, true
+			}
+		}
+	}
+	return len(s), false
+}
+
+// dollarTag returns the $tag$ opening a Postgres dollar quoted string at
+// the start of s, or "" when s does not start with one.
+func dollarTag(s string) string {
+	for j := 1; j < len(s); j++ {
+		switch {
+		case s[j] == '$':
+			return s[:j+1]
+		case isIdentStart(s[j]), j > 1 && s[j] >= '0' && s[j] <= '9':
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+// parseStatements classifies each statement of a SQL string.
+func parseStatements(s string) ([]sqlStatement, error) {
+	tokens, err := tokenizeSQL(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []sqlStatement
+	var current []sqlToken
+	for _, t := range append(tokens, sqlToken{text: ";"}) {
+		if t.text == ";" && !t.quoted {
+			if len(current) > 0 {
+				statement := classifyStatement(current)
+				for _, s := range append([]sqlStatement{statement}, statement.nested...) {
+					if len(s.tables) == 0 && (s.kind == kindInsert || s.kind == kindUpdate || s.kind == kindDelete) {
+						return nil, fmt.Errorf("cannot tell which table a %s statement writes", s.kind)
+					}
+				}
+				statements = append(statements, statement)
+			}
+			current = nil
+			continue
+		}
+		current = append(current, t)
+	}
+	return statements, nil
+}
+
+// tableName reads a possibly schema qualified name starting at tokens[i]
+// and returns it along with the index following it.
+func tableName(tokens []sqlToken, i int) (string, int) {
+	if i >= len(tokens) || tokens[i].text == "(" || tokens[i].text == "'" || isKeyword(tokens[i]) {
+		return "", i
+	}
+	name := tokens[i].text
+	i++
+	for i+1 < len(tokens) && tokens[i].text == "." {
+		name += "." + tokens[i+1].text
+		i += 2
+	}
+	return name, i
+}
+
+// classifyStatement finds the kind of a statement and the tables it
+// touches.
+func classifyStatement(tokens []sqlToken) sqlStatement {
+	ctes := make(map[string]bool)
+	verb := 0
+	if tokens[0].is("WITH") {
+		// Skip the common table expressions to find the main statement,
+		// remembering their names since they are not tables
+		i := 1
+		if i < len(tokens) && tokens[i].is("RECURSIVE") {
+			i++
+		}
+		for i < len(tokens) {
+			ctes[strings.ToLower(tokens[i].text)] = true
+			i = skipParens(tokens, i+1)
+			for i < len(tokens) && tokens[i].text != "(" {
+				i++
+			}
+			i = skipParens(tokens, i)
+			if i >= len(tokens) || tokens[i].text != "," {
+				break
+			}
+			i++
+		}
+		verb = i
+	}
+
+	statement := sqlStatement{kind: kindOther}
+	if verb < len(tokens) && !tokens[verb].quoted {
+		switch strings.ToUpper(tokens[verb].text) {
+		case "SELECT", "VALUES", "TABLE":
+			statement.kind = kindSelect
+		case "INSERT", "REPLACE":
+			statement.kind = kindInsert
+		case "UPDATE":
+			statement.kind = kindUpdate
+		case "DELETE":
+			statement.kind = kindDelete
+		case "CREATE", "ALTER", "DROP", "TRUNCATE", "RENAME", "COMMENT", "GRANT", "REVOKE":
+			statement.kind = kindDDL
+		}
+	}
+
+	seen := make(map[string]bool)
+	addTable := func(name string) {
+		key := strings.ToLower(name)
+		if name != "" && !ctes[key] && !seen[key] {
+			seen[key] = true
+			statement.tables = append(statement.tables, name)
+		}
+	}
+
+	// queryParens tracks, for each open parenthesis, whether it holds a
+	// query. FROM inside function calls such as EXTRACT(YEAR FROM x) does
+	// not name a table.
+	var queryParens []bool
+	inQuery := func() bool {
+		return len(queryParens) == 0 || queryParens[len(queryParens)-1]
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.text == "(" && !t.quoted && i+1 < len(tokens) && writeVerb(tokens[i+1]):
+			// A data-modifying statement in a WITH clause is classified on its
+			// own
+			end := skipParens(tokens, i)
+			body := tokens[i+1 : end]
+			if body[len(body)-1].text == ")" {
+				body = body[:len(body)-1]
+			}
+			inner := classifyStatement(body)
+			statement.nested = append(statement.nested, inner)
+			statement.nested = append(statement.nested, inner.nested...)
+			i = end - 1
+		case t.text == "(" && !t.quoted:
+			sub := i+1 < len(tokens) && (tokens[i+1].is("SELECT") || tokens[i+1].is("WITH"))
+			queryParens = append(queryParens, sub)
+		case t.text == ")" && !t.quoted:
+			if len(queryParens) > 0 {
+				queryParens = queryParens[:len(queryParens)-1]
+			}
+		case t.is("FROM") && inQuery(), t.is("JOIN"), t.is("USING") && statement.kind == kindDelete:
+			j := i + 1
+			if j < len(tokens) && tokens[j].is("ONLY") {
+				j++
+			}
+			for {
+				name, next := tableName(tokens, j)
+				addTable(name)
+				j = next
+				// Skip an alias
+				if j < len(tokens) && tokens[j].is("AS") {
+					j++
+				}
+				if j < len(tokens) && !isKeyword(tokens[j]) && !strings.Contains(",()'", tokens[j].text) {
+					j++
+				}
+				if name == "" || j >= len(tokens) || tokens[j].text != "," {
+					break
+				}
+				j++
+			}
+		case i == verb && (t.is("INSERT") || t.is("REPLACE") || t.is("UPDATE")):
+			// MySQL inserts need no INTO, and modifiers may come first
+			j := skipModifiers(tokens, i+1)
+			if j < len(tokens) && tokens[j].is("INTO") {
+				continue
+			}
+			if j < len(tokens) && tokens[j].is("ONLY") {
+				j++
+			}
+			name, _ := tableName(tokens, j)
+			addTable(name)
+		case t.is("INTO") && statement.kind == kindSelect:
+			// SELECT INTO writes its rows to a new table, or on MySQL to a
+			// file or variables
+			into := sqlStatement{kind: kindDDL}
+			j := i + 1
+			for j < len(tokens) && (tokens[j].is("TEMPORARY") || tokens[j].is("TEMP") || tokens[j].is("UNLOGGED") || tokens[j].is("TABLE")) {
+				j++
+			}
+			if j >= len(tokens) || tokens[j].is("OUTFILE") || tokens[j].is("DUMPFILE") || tokens[j].text == "@" {
+				into.kind = kindOther
+			} else if name, _ := tableName(tokens, j); name != "" {
+				into.tables = []string{name}
+			}
+			statement.nested = append(statement.nested, into)
+		case i == verb && t.is("TABLE"), t.is("INTO"), t.is("TRUNCATE"):
+			j := i + 1
+			if j < len(tokens) && (tokens[j].is("TABLE") || tokens[j].is("ONLY")) {
+				j++
+			}
+			name, _ := tableName(tokens, j)
+			addTable(name)
+		case statement.kind == kindDDL && (t.is("TABLE") || t.is("VIEW") || t.is("ON")):
+			j := skipIfExists(tokens, i+1)
+			if t.is("ON") && j < len(tokens) && (tokens[j].is("DELETE") || tokens[j].is("UPDATE")) {
+				// A foreign key action, not a table
+				continue
+			}
+			for {
+				name, next := tableName(tokens, j)
+				addTable(name)
+				if name == "" || !t.is("TABLE") || next >= len(tokens) || tokens[next].text != "," {
+					break
+				}
+				j = next + 1
+			}
+		}
+	}
+	return statement
+}
+
+// writeVerb reports whether t starts a statement that changes rows.
+func writeVerb(t sqlToken) bool {
+	return t.is("INSERT") || t.is("REPLACE") || t.is("UPDATE") || t.is("DELETE") || t.is("MERGE")
+}
+
+// skipModifiers returns the index following the modifiers, such as
+// IGNORE or SQLite's OR REPLACE, that start at tokens[i].
+func skipModifiers(tokens []sqlToken, i int) int {
+	for i < len(tokens) {
+		switch {
+		case tokens[i].is("OR"):
+			i += 2
+		case tokens[i].is("LOW_PRIORITY"), tokens[i].is("DELAYED"), tokens[i].is("HIGH_PRIORITY"), tokens[i].is("IGNORE"):
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// skipIfExists returns the index following an IF [NOT] EXISTS clause
+// starting at tokens[i], or i when there is none.
+func skipIfExists(tokens []sqlToken, i int) int {
+	if i >= len(tokens) || !tokens[i].is("IF") {
+		return i
+	}
+	i++
+	if i < len(tokens) && tokens[i].is("NOT") {
+		i++
+	}
+	return i + 1
+}
+
+// skipParens returns the index following the parenthesized group that
+// starts at tokens[i], or i when tokens[i] does not open one.
+func skipParens(tokens []sqlToken, i int) int {
+	if i >= len(tokens) || tokens[i].text != "(" {
+		return i
+	}
+	depth := 0
+	for ; i < len(tokens); i++ {
+		switch tokens[i].text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+// loadRawPolicy reads a raw policy from a JSON file.
+func loadRawPolicy(path string) (*RawPolicy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy RawPolicy
+	if err := json.Unmarshal(b, &policy); err != nil {
+		return nil, err
+	}
+
+	if policy.Default == "" {
+		policy.Default = "deny"
+	}
+	if policy.Default != "allow" && policy.Default != "deny" {
+		return nil, fmt.Errorf("default must be allow or deny, not %q", policy.Default)
+	}
+	for i, rule := range policy.Rules {
+		if rule.Action != "allow" && rule.Action != "deny" {
+			return nil, fmt.Errorf("rule %d: action must be allow or deny, not %q", i, rule.Action)
+		}
+	}
+	return &policy, nil
+}
+
+func (rule PolicyRule) matches(kind, table string) bool {
+	kindMatch := len(rule.Statements) == 0
+	for _, s := range rule.Statements {
+		if s == "*" || strings.EqualFold(s, kind) {
+			kindMatch = true
+			break
+		}
+	}
+	if !kindMatch {
+		return false
+	}
+
+	if len(rule.Tables) == 0 {
+		return true
+	}
+	unqualified := table[strings.LastIndex(table, ".")+1:]
+	for _, t := range rule.Tables {
+		if t == "*" || table != "" && (strings.EqualFold(t, table) || strings.EqualFold(t, unqualified)) {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether the policy lets a statement kind touch a table.
+// Statements that touch no table are checked with an empty table name.
+func (p *RawPolicy) allows(kind, table string) bool {
+	for _, rule := range p.Rules {
+		if rule.matches(kind, table) {
+			return rule.Action == "allow"
+		}
+	}
+	return p.Default == "allow"
+}
+
+// check returns an error describing the first statement of sql the policy
+// denies. Only a single statement is allowed, along with any statements
+// nested in it.
+func (p *RawPolicy) check(sql string) error {
+	if p == nil {
+		return nil
+	}
+
+	statements, err := parseStatements(sql)
+	if err != nil {
+		return err
+	}
+	if len(statements) > 1 {
+		return errors.New("only one statement at a time is allowed by the raw policy")
+	}
+	for _, statement := range statements {
+		statements = append(statements, statement.nested...)
+	}
+
+	for _, statement := range statements {
+		if len(statement.tables) == 0 {
+			if !p.allows(statement.kind, "") {
+				return fmt.Errorf("%s statements are not allowed by the raw policy", statement.kind)
+			}
+			continue
+		}
+		for _, table := range statement.tables {
+			if !p.allows(statement.kind, table) {
+				return fmt.Errorf("%s on %s is not allowed by the raw policy", statement.kind, table)
+			}
+		}
+	}
+	return nil
+}
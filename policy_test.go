@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatements(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		sql    string
+		kind   string
+		tables []string
+	}{
+		{"SELECT * FROM users", kindSelect, []string{"users"}},
+		{"select 1", kindSelect, nil},
+		{"SELECT * FROM users u, public.orders AS o WHERE u.id = o.user_id", kindSelect, []string{"users", "public.orders"}},
+		{"SELECT * FROM a LEFT JOIN b ON a.id = b.a_id", kindSelect, []string{"a", "b"}},
+		{"SELECT EXTRACT(YEAR FROM created) FROM events", kindSelect, []string{"events"}},
+		{"SELECT * FROM (SELECT * FROM inner_t) x", kindSelect, []string{"inner_t"}},
+		{"SELECT 'FROM secrets' FROM t -- FROM comments", kindSelect, []string{"t"}},
+		{`SELECT * FROM "Mixed Case"`, kindSelect, []string{"Mixed Case"}},
+		{"INSERT INTO events (name) VALUES ('x')", kindInsert, []string{"events"}},
+		{"INSERT INTO archive SELECT * FROM events", kindInsert, []string{"archive", "events"}},
+		{"UPDATE users SET name = 'x' WHERE id IN (SELECT id FROM banned)", kindUpdate, []string{"users", "banned"}},
+		{"DELETE FROM users WHERE id = 1", kindDelete, []string{"users"}},
+		{"WITH recent AS (SELECT * FROM events) DELETE FROM users WHERE id IN (SELECT user_id FROM recent)", kindDelete, []string{"events", "users"}},
+		{"WITH x (a) AS (SELECT 1) SELECT * FROM x", kindSelect, nil},
+		{"CREATE TABLE IF NOT EXISTS logs (id INT, user_id INT REFERENCES users (id) ON DELETE CASCADE)", kindDDL, []string{"logs"}},
+		{"DROP TABLE a, b", kindDDL, []string{"a", "b"}},
+		{"CREATE INDEX idx ON events (name)", kindDDL, []string{"events"}},
+		{"TRUNCATE TABLE events", kindDDL, []string{"events"}},
+		{"PRAGMA table_info(users)", kindOther, nil},
+		{"INSERT OR REPLACE INTO events (name) VALUES ('x')", kindInsert, []string{"events"}},
+		{"UPDATE OR IGNORE users SET name = 'x'", kindUpdate, []string{"users"}},
+		{"SELECT * FROM [odd table]", kindSelect, []string{"odd table"}},
+	}
+
+	*dbtype = "sqlite3"
+	for _, test := range tests {
+		statements, err := parseStatements(test.sql)
+		assert.Nil(err, test.sql)
+		assert.Len(statements, 1, test.sql)
+		assert.Equal(statements[0].kind, test.kind, test.sql)
+		assert.Equal(statements[0].tables, test.tables, test.sql)
+	}
+
+	statements, _ := parseStatements("SELECT * FROM a; DROP TABLE b;")
+	assert.Len(statements, 2)
+	assert.Equal(statements[1].kind, kindDDL)
+
+	statements, _ = parseStatements("WITH d AS (DELETE FROM users RETURNING *) SELECT * FROM d")
+	assert.Equal(statements[0].kind, kindSelect)
+	assert.Equal(statements[0].nested[0].kind, kindDelete)
+	assert.Equal(statements[0].nested[0].tables, []string{"users"})
+
+	statements, _ = parseStatements("SELECT * INTO copy FROM users")
+	assert.Equal(statements[0].nested[0].kind, kindDDL)
+	assert.Equal(statements[0].nested[0].tables, []string{"copy"})
+
+	for _, sql := range []string{"SELECT 'open", `SELECT * FROM "open`, "SELECT 1 /* open", "SELECT * FROM [open"} {
+		_, err := parseStatements(sql)
+		assert.NotNil(err, sql)
+	}
+
+	*dbtype = "mysql"
+	statements, _ = parseStatements("INSERT IGNORE users VALUES (1)")
+	assert.Equal(statements[0].tables, []string{"users"})
+	statements, _ = parseStatements("SELECT * FROM a WHERE b = 'it\\'s'")
+	assert.Len(statements, 1)
+	_, err := parseStatements("SELECT 1 /*! ; DROP TABLE b */")
+	assert.NotNil(err)
+	*dbtype = "sqlite3"
+}
+
+func TestRawPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := &RawPolicy{
+		Default: "deny",
+		Rules: []PolicyRule{
+			{Action: "deny", Statements: []string{"SELECT"}, Tables: []string{"secrets"}},
+			{Action: "allow", Statements: []string{"SELECT"}, Tables: []string{"*"}},
+			{Action: "allow", Statements: []string{"INSERT"}, Tables: []string{"events"}},
+		},
+	}
+
+	assert.Nil(policy.check("SELECT * FROM users"))
+	assert.Nil(policy.check("SELECT 1"))
+	assert.Nil(policy.check("INSERT INTO events (a) VALUES (1)"))
+	assert.Nil(policy.check("INSERT INTO public.events (a) VALUES (1)"))
+	assert.EqualError(policy.check("SELECT * FROM secrets"), "SELECT on secrets is not allowed by the raw policy")
+	assert.EqualError(policy.check("INSERT INTO users (a) VALUES (1)"), "INSERT on users is not allowed by the raw policy")
+	assert.EqualError(policy.check("INSERT INTO events SELECT * FROM secrets"), "INSERT on secrets is not allowed by the raw policy")
+	assert.EqualError(policy.check("SELECT 1; DROP TABLE users"), "only one statement at a time is allowed by the raw policy")
+	assert.EqualError(policy.check("BEGIN"), "OTHER statements are not allowed by the raw policy")
+
+	// Data-modifying WITH clauses and SELECT INTO are writes
+	assert.EqualError(policy.check("WITH d AS (DELETE FROM users RETURNING *) SELECT * FROM d"), "DELETE on users is not allowed by the raw policy")
+	assert.EqualError(policy.check("SELECT * INTO copy FROM users"), "DDL on copy is not allowed by the raw policy")
+
+	// Each database's quoting and comment rules are followed, so a second
+	// statement cannot hide in what looks like a string or comment
+	defer func() { *dbtype = "sqlite3" }()
+	bypasses := map[string][]string{
+		"sqlite3": {
+			`SELECT '\'; DELETE FROM users; --'`,
+			`SELECT 1 AS [']; DELETE FROM users; --']`,
+		},
+		"postgres": {
+			`SELECT '\'; DELETE FROM users; --'`,
+			`SELECT E'\''; DELETE FROM users; --'`,
+			`SELECT $$'$$; DELETE FROM users; --'`,
+			`SELECT $tag$'$tag$; DELETE FROM users; --'`,
+			`SELECT 1 /* /* */ ' */; DELETE FROM users; -- '`,
+		},
+		"mysql": {
+			"SELECT 1 #'\n; DELETE FROM users; -- '",
+			"SELECT 1 --1; DELETE FROM users",
+			`SELECT "\""; DELETE FROM users; -- "`,
+		},
+	}
+	for db, queries := range bypasses {
+		*dbtype = db
+		for _, sql := range queries {
+			assert.EqualError(policy.check(sql), "only one statement at a time is allowed by the raw policy", db+": "+sql)
+		}
+	}
+
+	var none *RawPolicy
+	assert.Nil(none.check("DROP TABLE users"))
+}
+
+func TestLoadRawPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "sqld")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policy.json")
+	ioutil.WriteFile(path, []byte(`{"rules": [{"action": "allow", "statements": ["SELECT"]}]}`), 0644)
+	policy, err := loadRawPolicy(path)
+	assert.Nil(err)
+	assert.Equal(policy.Default, "deny")
+
+	ioutil.WriteFile(path, []byte(`{"rules": [{"action": "maybe"}]}`), 0644)
+	_, err = loadRawPolicy(path)
+	assert.Contains(err.Error(), "rule 0")
+
+	_, err = loadRawPolicy(filepath.Join(dir, "missing.json"))
+	assert.NotNil(err)
+}
+
+func TestRawForbidden(t *testing.T) {
+	assert := assert.New(t)
+
+	createDB()
+	defer closeDB()
+
+	rawPolicy = &RawPolicy{
+		Default: "deny",
+		Rules:   []PolicyRule{{Action: "allow", Statements: []string{"SELECT"}}},
+	}
+	defer func() { rawPolicy = nil }()
+
+	b := bytes.NewBufferString(`{"read": "SELECT * FROM t1"}`)
+	req, _ := http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr := raw(req)
+	assert.Nil(sqldErr)
+
+	b = bytes.NewBufferString(`{"write": "DELETE FROM t1"}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr = raw(req)
+	assert.Equal(sqldErr.Code, http.StatusForbidden)
+	assert.Equal(sqldErr.Error(), "DELETE on t1 is not allowed by the raw policy")
+
+	b = bytes.NewBufferString(`{"write": "SELECT '\\'; DELETE FROM t1; --'"}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr = raw(req)
+	assert.Equal(sqldErr.Code, http.StatusForbidden)
+
+	var count int
+	db.Get(&count, "SELECT COUNT(*) FROM t1")
+	assert.Equal(count, 2)
+
+	b = bytes.NewBufferString(`{"statements": [{"read": "SELECT 1"}, {"write": "DROP TABLE t1"}]}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr = raw(req)
+	assert.Equal(sqldErr.Code, http.StatusForbidden)
+	assert.Contains(sqldErr.Error(), "statement 1:")
+}
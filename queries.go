@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// queriesRoute is the url path, relative to the url prefix, that serves
+// saved queries
+const queriesRoute = "_queries"
+
+// savedQueries holds the queries loaded from the queries flag directory,
+// keyed by name
+var savedQueries = make(map[string]*SavedQuery)
+
+// QueryParam is a parameter declared in the header of a saved query
+type QueryParam struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type"`
+	Required bool        `json:"required"`
+	Default  interface{} `json:"default,omitempty"`
+}
+
+// SavedQuery is a vetted SQL query loaded from a .sql file. The file name,
+// without its extension, names the query and leading comment lines make up
+// its header:
+//
+//	-- Orders placed by a customer
+//	-- @param customer int
+//	-- @param status string pending
+//	SELECT * FROM orders WHERE customer_id = :customer AND status = :status
+//
+// Parameters are declared with a name, a type (string, int, float, bool or
+// time) and an optional default value. Parameters without a default are
+// required. Other header lines describe the query.
+type SavedQuery struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Params      []QueryParam `json:"params"`
+	SQL         string       `json:"-"`
+}
+
+// paramTypes are the types a saved query parameter may be declared with
+var paramTypes = map[string]bool{
+	"string": true,
+	"int":    true,
+	"float":  true,
+	"bool":   true,
+	"time":   true,
+}
+
+// parseParamValue converts a parameter value to its declared type.
+func parseParamValue(kind, value string) (interface{}, error) {
+	switch kind {
+	case "string":
+		return value, nil
+	case "int":
+		return strconv.ParseInt(value, 10, 64)
+	case "float":
+		return strconv.ParseFloat(value, 64)
+	case "bool":
+		return strconv.ParseBool(value)
+	case "time":
+		return time.Parse(time.RFC3339, value)
+	}
+	return nil, fmt.Errorf("unknown parameter type %q", kind)
+}
+
+// parseSavedQuery parses the header and body of a saved query file.
+func parseSavedQuery(name, text string) (*SavedQuery, error) {
+	query := &SavedQuery{Name: name, Params: []QueryParam{}}
+
+	lines := strings.Split(text, "\n")
+	var description []string
+	i := 0
+	for ; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "--"))
+		if !strings.HasPrefix(line, "@param") {
+			if line != "" {
+				description = append(description, line)
+			}
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(line, "@param"))
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s: @param needs a name and a type", name)
+		}
+		param := QueryParam{Name: fields[0], Type: fields[1], Required: true}
+		if !paramTypes[param.Type] {
+			return nil, fmt.Errorf("%s: unknown type %q for %s", name, param.Type, param.Name)
+		}
+		if len(fields) > 2 {
+			def, err := parseParamValue(param.Type, strings.Join(fields[2:], " "))
+			if err != nil {
+				return nil, fmt.Errorf("%s: default for %s: %s", name, param.Name, err)
+			}
+			param.Default = def
+			param.Required = false
+		}
+		query.Params = append(query.Params, param)
+	}
+
+	query.Description = strings.Join(description, " ")
+	query.SQL = strings.TrimSpace(strings.Join(lines[i:], "\n"))
+	if query.SQL == "" {
+		return nil, fmt.Errorf("%s: no SQL after the header", name)
+	}
+
+	// Every placeholder in the query must be a declared parameter
+	declared := make(map[string]interface{}, len(query.Params))
+	for _, param := range query.Params {
+		declared[param.Name] = nil
+	}
+	if _, _, err := sqlx.Named(query.SQL, declared); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return query, nil
+}
+
+// loadQueries reads every .sql file in dir as a saved query.
+func loadQueries(dir string) (map[string]*SavedQuery, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+
+	queries := make(map[string]*SavedQuery, len(files))
+	for _, file := range files {
+		text, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".sql")
+		query, err := parseSavedQuery(name, string(text))
+		if err != nil {
+			return nil, err
+		}
+		queries[name] = query
+	}
+	return queries, nil
+}
+
+// bind builds the statement and arguments of a saved query from the
+// parameters of a request. A query without parameters is run exactly as
+// written.
+func (q *SavedQuery) bind(ext sqlx.Ext, values map[string][]string) (string, []interface{}, error) {
+	params := make(map[string]interface{}, len(q.Params))
+	for _, param := range q.Params {
+		value, ok := values[param.Name]
+		if !ok {
+			if param.Required {
+				return "", nil, fmt.Errorf("missing parameter %s", param.Name)
+			}
+			params[param.Name] = param.Default
+			continue
+		}
+
+		v, err := parseParamValue(param.Type, value[0])
+		if err != nil {
+			return "", nil, fmt.Errorf("parameter %s: %s", param.Name, err)
+		}
+		params[param.Name] = v
+	}
+
+	for key := range values {
+		if _, ok := params[key]; !ok && !strings.HasPrefix(key, "__") {
+			return "", nil, fmt.Errorf("unknown parameter %s", key)
+		}
+	}
+
+	if len(q.Params) == 0 {
+		return q.SQL, nil, nil
+	}
+
+	sql, args, err := sqlx.Named(q.SQL, params)
+	if err != nil {
+		return "", nil, err
+	}
+	sql, err = rebindSQL(ext, sql)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, args, nil
+}
+
+// handleQueries handles requests to the saved queries route:
+//
+//	GET {url}_queries        lists the saved queries and their parameters
+//	GET {url}_queries/:name  runs a saved query
+func handleQueries(r *http.Request) (interface{}, *SqldError) {
+	if r.Method != "GET" {
+		return nil, NewError(nil, http.StatusMethodNotAllowed)
+	}
+
+	paths := routePaths(r)
+	if len(paths) == 1 {
+		index := make([]*SavedQuery, 0, len(savedQueries))
+		for _, query := range savedQueries {
+			index = append(index, query)
+		}
+		sort.Slice(index, func(i, j int) bool { return index[i].Name < index[j].Name })
+		return index, nil
+	}
+	if len(paths) != 2 {
+		return nil, NotFound(nil)
+	}
+
+	query, ok := savedQueries[paths[1]]
+	if !ok {
+		return nil, NotFound(errors.New("unknown query " + paths[1]))
+	}
+
+	format, sqldErr := requestedRowFormat(r)
+	if sqldErr != nil {
+		return nil, sqldErr
+	}
+
+	ext, release, sqldErr := executor(r)
+	if sqldErr != nil {
+		return nil, sqldErr
+	}
+
+	sql, args, err := query.bind(ext, r.URL.Query())
+	if err != nil {
+		release()
+		return nil, BadRequest(err)
+	}
+	if format != nil {
+		return streamQuery(r, ext, format, query.Name, sql, args, release)
+	}
+	defer release()
+
+	tableData, err := readQuery(ext, sql, args)
+	if err != nil {
+		return nil, InternalError(err)
+	}
+	return tableData, nil
+}
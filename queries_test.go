@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSavedQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	query, err := parseSavedQuery("by_a", `-- Rows by a
+-- @param a string
+-- @param limit int 10
+
+SELECT * FROM t1 WHERE a = :a LIMIT :limit
+`)
+	assert.Nil(err)
+	assert.Equal(query.Description, "Rows by a")
+	assert.Equal(query.SQL, "SELECT * FROM t1 WHERE a = :a LIMIT :limit")
+	assert.Equal(query.Params, []QueryParam{
+		{Name: "a", Type: "string", Required: true},
+		{Name: "limit", Type: "int", Default: int64(10)},
+	})
+
+	_, err = parseSavedQuery("bad", "-- @param a uuid\nSELECT :a")
+	assert.Contains(err.Error(), "unknown type")
+
+	_, err = parseSavedQuery("bad", "-- @param a int ten\nSELECT :a")
+	assert.Contains(err.Error(), "default for a")
+
+	_, err = parseSavedQuery("bad", "-- @param a int\nSELECT :a, :b")
+	assert.NotNil(err)
+
+	_, err = parseSavedQuery("bad", "-- just a header\n")
+	assert.Contains(err.Error(), "no SQL")
+}
+
+func TestSavedQueryBind(t *testing.T) {
+	assert := assert.New(t)
+	pg := sqlx.NewDb(nil, "postgres")
+	defer func(t string) { *dbtype = t }(*dbtype)
+	*dbtype = "postgres"
+
+	query, _ := parseSavedQuery("faq", "-- @param q string\nSELECT * FROM faq WHERE q = :q OR q = 'why?'")
+	sql, args, err := query.bind(pg, map[string][]string{"q": {"how"}})
+	assert.Nil(err)
+	assert.Equal(sql, "SELECT * FROM faq WHERE q = $1 OR q = 'why?'")
+	assert.Equal(args, []interface{}{"how"})
+
+	query, _ = parseSavedQuery("faq", "SELECT * FROM faq WHERE data ? 'k'")
+	sql, args, err = query.bind(pg, nil)
+	assert.Nil(err)
+	assert.Equal(sql, "SELECT * FROM faq WHERE data ? 'k'")
+	assert.Nil(args)
+}
+
+func TestHandleQueries(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+
+	dir, _ := ioutil.TempDir("", "sqld")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "by_a.sql"), []byte("-- @param a string\nSELECT * FROM t1 WHERE a = :a"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "all.sql"), []byte("SELECT * FROM t1"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a query"), 0644)
+
+	var err error
+	savedQueries, err = loadQueries(dir)
+	assert.Nil(err)
+	assert.Len(savedQueries, 2)
+	defer func() { savedQueries = make(map[string]*SavedQuery) }()
+
+	req, _ := http.NewRequest("GET", "http://example.com/_queries", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	var index []map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &index)
+	assert.Len(index, 2)
+	assert.Equal(index[0]["name"], "all")
+	assert.Equal(index[1]["name"], "by_a")
+
+	req, _ = http.NewRequest("GET", "http://example.com/_queries/by_a?a=how", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	data := []TestData{}
+	json.Unmarshal(w.Body.Bytes(), &data)
+	assert.Len(data, 1)
+	assert.Equal(data[0].B, "dy")
+
+	req, _ = http.NewRequest("GET", "http://example.com/_queries/by_a?a=how&__format__=csv", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK)
+	assert.Equal(w.Header().Get("Content-Disposition"), "attachment; filename=by_a.csv")
+	assert.Equal(w.Body.String(), "a,b\r\nhow,dy\r\n")
+
+	req, _ = http.NewRequest("GET", "http://example.com/_queries/by_a", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusBadRequest)
+
+	req, _ = http.NewRequest("GET", "http://example.com/_queries/by_a?a=how&b=dy", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusBadRequest)
+
+	req, _ = http.NewRequest("GET", "http://example.com/_queries/nope", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusNotFound)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// bindRaw binds the args or params of a raw query to its statement and
+// rewrites `?` placeholders into the format of the database driver. A
+// statement sent without either is run exactly as written.
+func bindRaw(ext sqlx.Ext, statement string, query RawQuery) (string, []interface{}, error) {
+	if len(query.Args) > 0 && len(query.Params) > 0 {
+		return "", nil, errors.New("a raw query takes either args or params, not both")
+	}
+	if len(query.Args) == 0 && len(query.Params) == 0 {
+		return statement, nil, nil
+	}
+
+	var args []interface{}
+	if len(query.Params) > 0 {
+		params := make(map[string]interface{}, len(query.Params))
+		for name, val := range query.Params {
+			params[name] = bindValue(val)
+		}
+
+		var err error
+		statement, args, err = sqlx.Named(statement, params)
+		if err != nil {
+			return "", nil, err
+		}
+	} else {
+		args = make([]interface{}, len(query.Args))
+		for i, val := range query.Args {
+			args[i] = bindValue(val)
+		}
+	}
+
+	statement, err := rebindSQL(ext, statement)
+	if err != nil {
+		return "", nil, err
+	}
+	return statement, args, nil
+}
+
+// rebindSQL rewrites the `?` placeholders of a statement into the format of
+// the database driver. Unlike sqlx's Rebind it leaves question marks inside
+// strings, quoted identifiers and comments alone.
+func rebindSQL(ext sqlx.Ext, statement string) (string, error) {
+	bindType := sqlx.BindType(ext.DriverName())
+	if bindType == sqlx.QUESTION || bindType == sqlx.UNKNOWN {
+		return statement, nil
+	}
+
+	tokens, err := tokenizeSQL(statement)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	last, n := 0, 0
+	for _, t := range tokens {
+		if t.quoted || t.text != "?" {
+			continue
+		}
+		n++
+		b.WriteString(statement[last:t.pos])
+		switch bindType {
+		case sqlx.DOLLAR:
+			fmt.Fprintf(&b, "$%d", n)
+		case sqlx.NAMED:
+			fmt.Fprintf(&b, ":arg%d", n)
+		case sqlx.AT:
+			fmt.Fprintf(&b, "@p%d", n)
+		}
+		last = t.pos + 1
+	}
+	b.WriteString(statement[last:])
+	return b.String(), nil
+}
+
+// bindValue converts a value decoded from a JSON request body into one the
+// database drivers accept. Numbers are kept exact, and arrays and objects
+// are bound as their JSON text.
+func bindValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case []interface{}, map[string]interface{}:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return val
+}
+
+// checkRead refuses a raw read that is not a single SELECT statement or a
+// statement that describes the database, such as SHOW or EXPLAIN.
+func checkRead(sql string) *SqldError {
+	statements, err := parseStatements(sql)
+	if err != nil {
+		return BadRequest(err)
+	}
+	if len(statements) == 1 {
+		s := statements[0]
+		if s.kind == kindSelect && len(s.nested) == 0 || s.kind == kindOther && describes(sql) {
+			return nil
+		}
+	}
+	return BadRequest(errors.New("a read must be a single SELECT, SHOW, DESCRIBE, EXPLAIN or PRAGMA statement"))
+}
+
+// readPragmas are the SQLite pragmas that only report on the schema.
+var readPragmas = map[string]bool{
+	"collation_list":   true,
+	"database_list":    true,
+	"foreign_key_list": true,
+	"function_list":    true,
+	"index_info":       true,
+	"index_list":       true,
+	"index_xinfo":      true,
+	"table_info":       true,
+	"table_list":       true,
+	"table_xinfo":      true,
+}
+
+// describes reports whether a statement only describes the database.
+// EXPLAIN ANALYZE is refused since it runs the statement it explains.
+func describes(sql string) bool {
+	tokens, err := tokenizeSQL(sql)
+	if err != nil || len(tokens) == 0 || tokens[0].quoted {
+		return false
+	}
+	switch strings.ToUpper(tokens[0].text) {
+	case "SHOW":
+		return true
+	case "EXPLAIN", "DESCRIBE", "DESC":
+		for _, t := range tokens {
+			if t.is("ANALYZE") || t.is("ANALYSE") {
+				return false
+			}
+		}
+		return true
+	case "PRAGMA":
+		name := 1
+		if len(tokens) > 3 && tokens[2].text == "." {
+			name = 3
+		}
+		return *dbtype == "sqlite3" && len(tokens) > name && !tokens[name].quoted &&
+			readPragmas[strings.ToLower(tokens[name].text)]
+	}
+	return false
+}
+
+// joinRead refuses raw reads in a client transaction that can write. It
+// leaves unknown transactions to fail when they are joined.
+func joinRead(r *http.Request) *SqldError {
+	id := r.Header.Get(txHeader)
+	if readOnly, found := txReadOnly(id); found && !readOnly {
+		return BadRequest(errors.New("raw reads can only join a transaction started with " + txRoute + "?read_only=true"))
+	}
+	return nil
+}
+
+// rawRead runs a raw read query in a read-only transaction, so the read
+// channel cannot be used to change data.
+func rawRead(r *http.Request, query RawQuery) (interface{}, *SqldError) {
+	if sqldErr := checkRead(query.ReadQuery); sqldErr != nil {
+		return nil, sqldErr
+	}
+	format, sqldErr := requestedRowFormat(r)
+	if sqldErr != nil {
+		return nil, sqldErr
+	}
+
+	var ext sqlx.Ext
+	var release func()
+	if r.Header.Get(txHeader) != "" {
+		if sqldErr := joinRead(r); sqldErr != nil {
+			return nil, sqldErr
+		}
+		ext, release, sqldErr = executor(r)
+	} else {
+		ext, release, sqldErr = beginReadOnly()
+	}
+	if sqldErr != nil {
+		return nil, sqldErr
+	}
+
+	if format == nil {
+		defer release()
+		return runRaw(ext, query)
+	}
+
+	if err := rawPolicy.check(query.ReadQuery); err != nil {
+		release()
+		return nil, Forbidden(err)
+	}
+	sql, args, err := bindRaw(ext, query.ReadQuery, query)
+	if err != nil {
+		release()
+		return nil, BadRequest(err)
+	}
+	return streamQuery(r, ext, format, "query", sql, args, release)
+}
+
+// readOnlyScript reports whether every statement of a script is a read.
+func readOnlyScript(statements []RawQuery) bool {
+	for _, statement := range statements {
+		if statement.ReadQuery == "" || statement.WriteQuery != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// scriptReads reports whether any statement of a script is a read.
+func scriptReads(statements []RawQuery) bool {
+	for _, statement := range statements {
+		if statement.ReadQuery != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// rawScript runs the statements of a raw script in order inside one
+// transaction and returns the result of each. The first failing statement
+// rolls back the whole script and is reported by its index. Scripts made up
+// only of reads run in a read-only transaction, and reads must be single
+// SELECT statements.
+func rawScript(r *http.Request, statements []RawQuery) (interface{}, *SqldError) {
+	script := func(ext sqlx.Ext) (interface{}, *SqldError) {
+		results := make([]interface{}, len(statements))
+		for i, statement := range statements {
+			if len(statement.Statements) > 0 {
+				return nil, BadRequest(fmt.Errorf("statement %d: scripts cannot be nested", i))
+			}
+			if statement.ReadQuery == "" && statement.WriteQuery == "" {
+				return nil, BadRequest(fmt.Errorf("statement %d: needs a read or write query", i))
+			}
+
+			result, err := runRaw(ext, statement)
+			if err != nil {
+				return nil, NewError(fmt.Errorf("statement %d: %s", i, err), err.Code)
+			}
+			results[i] = result
+		}
+		return results, nil
+	}
+
+	if r.Header.Get(txHeader) != "" {
+		if scriptReads(statements) {
+			if sqldErr := joinRead(r); sqldErr != nil {
+				return nil, sqldErr
+			}
+		}
+		return withTx(r, script)
+	}
+	if readOnlyScript(statements) {
+		return withReadOnlyTx(script)
+	}
+	return withTx(r, script)
+}
+
+// rawRegistry holds the raw queries registered ahead of time with the
+// raw-registry flag, keyed by the SHA-256 hash of their SQL
+var rawRegistry = make(map[string]RawQuery)
+
+// queryHash returns the hex encoded SHA-256 hash of a query text.
+func queryHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadRawRegistry reads a JSON array of registered read and write queries:
+//
+//	[
+//	  {"read": "SELECT * FROM users WHERE id = ?"},
+//	  {"write": "INSERT INTO events (name) VALUES (:name)"}
+//	]
+func loadRawRegistry(path string) (map[string]RawQuery, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var queries []RawQuery
+	if err := json.Unmarshal(b, &queries); err != nil {
+		return nil, err
+	}
+
+	registry := make(map[string]RawQuery, len(queries))
+	for i, query := range queries {
+		if (query.ReadQuery == "") == (query.WriteQuery == "") {
+			return nil, fmt.Errorf("query %d: needs exactly one of read or write", i)
+		}
+		registry[queryHash(query.ReadQuery+query.WriteQuery)] = RawQuery{
+			ReadQuery:  query.ReadQuery,
+			WriteQuery: query.WriteQuery,
+		}
+	}
+	return registry, nil
+}
+
+// resolveRaw fills in the SQL of a raw query sent by hash. In strict mode
+// query text that is not in the registry is refused.
+func resolveRaw(query *RawQuery) *SqldError {
+	if query.Hash != "" {
+		if query.ReadQuery != "" || query.WriteQuery != "" {
+			return BadRequest(errors.New("a raw query takes either a hash or query text, not both"))
+		}
+		registered, ok := rawRegistry[strings.ToLower(query.Hash)]
+		if !ok {
+			return NotFound(errors.New("no registered query has hash " + query.Hash))
+		}
+		query.ReadQuery = registered.ReadQuery
+		query.WriteQuery = registered.WriteQuery
+		return nil
+	}
+
+	if !*rawStrict || query.ReadQuery == "" && query.WriteQuery == "" {
+		return nil
+	}
+	registered, ok := rawRegistry[queryHash(query.ReadQuery+query.WriteQuery)]
+	if !ok || registered.ReadQuery != query.ReadQuery || registered.WriteQuery != query.WriteQuery {
+		return Forbidden(errors.New("only registered queries may run, send their hash instead"))
+	}
+	return nil
+}
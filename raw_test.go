@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBindRaw(t *testing.T) {
+	assert := assert.New(t)
+	pg := sqlx.NewDb(nil, "postgres")
+
+	sql, args, err := bindRaw(pg, "SELECT * FROM t WHERE a = ? AND b = ?", RawQuery{
+		Args: []interface{}{json.Number("1"), "two"},
+	})
+	assert.Nil(err)
+	assert.Equal(sql, "SELECT * FROM t WHERE a = $1 AND b = $2")
+	assert.Equal(args, []interface{}{int64(1), "two"})
+
+	sql, args, err = bindRaw(pg, "SELECT * FROM t WHERE a = :a AND b = CAST(:b AS text)", RawQuery{
+		Params: map[string]interface{}{"a": json.Number("1.5"), "b": []interface{}{"x"}},
+	})
+	assert.Nil(err)
+	assert.Equal(sql, "SELECT * FROM t WHERE a = $1 AND b = CAST($2 AS text)")
+	assert.Equal(args, []interface{}{1.5, `["x"]`})
+
+	defer func(t string) { *dbtype = t }(*dbtype)
+	*dbtype = "postgres"
+
+	sql, args, err = bindRaw(pg, "UPDATE faq SET q = 'why?' WHERE data ? 'k'", RawQuery{})
+	assert.Nil(err)
+	assert.Equal(sql, "UPDATE faq SET q = 'why?' WHERE data ? 'k'")
+	assert.Nil(args)
+
+	sql, args, err = bindRaw(pg, `UPDATE faq SET q = 'why?' /* or? */ WHERE "a?" = ? AND b = $$?$$ AND c = ?`, RawQuery{
+		Args: []interface{}{"x", "y"},
+	})
+	assert.Nil(err)
+	assert.Equal(sql, `UPDATE faq SET q = 'why?' /* or? */ WHERE "a?" = $1 AND b = $$?$$ AND c = $2`)
+	assert.Equal(args, []interface{}{"x", "y"})
+
+	_, _, err = bindRaw(pg, "SELECT :a", RawQuery{
+		Params: map[string]interface{}{"b": "b"},
+	})
+	assert.NotNil(err)
+
+	_, _, err = bindRaw(pg, "SELECT ?", RawQuery{
+		Args:   []interface{}{"a"},
+		Params: map[string]interface{}{"a": "a"},
+	})
+	assert.NotNil(err)
+}
+
+func TestRawArgs(t *testing.T) {
+	assert := assert.New(t)
+
+	createDB()
+	defer closeDB()
+
+	b := bytes.NewBufferString(`{
+		"read": "SELECT * FROM t1 WHERE a = ?",
+		"args": ["hi"]
+	}`)
+	req, _ := http.NewRequest("POST", "http://example.com/", b)
+	data, sqldErr := raw(req)
+	assert.Nil(sqldErr)
+	rows := data.([]map[string]interface{})
+	assert.Len(rows, 1)
+	assert.Equal(rows[0]["b"], "there")
+
+	b = bytes.NewBufferString(`{
+		"write": "UPDATE t1 SET b = :b WHERE a = :a",
+		"params": {"a": "how", "b": "do you do"}
+	}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	data, sqldErr = raw(req)
+	assert.Nil(sqldErr)
+	assert.Equal(data.(map[string]interface{})["rows_affected"], int64(1))
+
+	b = bytes.NewBufferString(`{
+		"read": "SELECT * FROM t1 WHERE a = :a",
+		"params": {"b": "hi"}
+	}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr = raw(req)
+	assert.Equal(sqldErr.Code, http.StatusBadRequest)
+}
+
+func TestRawScript(t *testing.T) {
+	assert := assert.New(t)
+
+	createDB()
+	defer closeDB()
+
+	b := bytes.NewBufferString(`{
+		"statements": [
+			{"write": "INSERT INTO t1 (a, b) VALUES (?, ?)", "args": ["new", "row"]},
+			{"write": "UPDATE t1 SET b = 'changed' WHERE a = 'hi'"},
+			{"read": "SELECT * FROM t1 WHERE a IN ('new', 'hi') ORDER BY a"}
+		]
+	}`)
+	req, _ := http.NewRequest("POST", "http://example.com/", b)
+	data, sqldErr := raw(req)
+	assert.Nil(sqldErr)
+	results := data.([]interface{})
+	assert.Len(results, 3)
+	assert.Equal(results[0].(map[string]interface{})["rows_affected"], int64(1))
+	rows := results[2].([]map[string]interface{})
+	assert.Equal(rows[0]["b"], "changed")
+	assert.Equal(rows[1]["b"], "row")
+
+	b = bytes.NewBufferString(`{
+		"statements": [
+			{"write": "DELETE FROM t1"},
+			{"write": "NOT VALID SQL"}
+		]
+	}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	data, sqldErr = raw(req)
+	assert.Nil(data)
+	assert.Equal(sqldErr.Code, http.StatusBadRequest)
+	assert.Contains(sqldErr.Error(), "statement 1:")
+
+	var count int
+	db.Get(&count, "SELECT COUNT(*) FROM t1")
+	assert.Equal(count, 3)
+
+	// A failed script leaves nothing behind in the client's transaction
+	id, _ := beginTx(false)
+	b = bytes.NewBufferString(`{
+		"statements": [
+			{"write": "DELETE FROM t1"},
+			{"write": "NOT VALID SQL"}
+		]
+	}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	req.Header.Set(txHeader, id)
+	_, sqldErr = raw(req)
+	assert.Contains(sqldErr.Error(), "statement 1:")
+
+	t1, _ := acquireTx(id)
+	assert.Nil(t1.finish(true))
+	t1.Unlock()
+	db.Get(&count, "SELECT COUNT(*) FROM t1")
+	assert.Equal(count, 3)
+
+	b = bytes.NewBufferString(`{"statements": [{"read": "SELECT 1"}, {}]}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr = raw(req)
+	assert.Contains(sqldErr.Error(), "statement 1: needs a read or write query")
+}
+
+func TestRawReadOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	createDB()
+	defer closeDB()
+
+	b := bytes.NewBufferString(`{"read": "DELETE FROM t1"}`)
+	req, _ := http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr := raw(req)
+	assert.Equal(sqldErr.Code, http.StatusBadRequest)
+	assert.Contains(sqldErr.Error(), "single SELECT")
+
+	b = bytes.NewBufferString(`{"statements": [{"read": "SELECT 1"}, {"read": "DELETE FROM t1"}]}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr = raw(req)
+	assert.Contains(sqldErr.Error(), "statement 1:")
+
+	// Reads sharing a script's transaction with writes cannot write either
+	b = bytes.NewBufferString(`{"statements": [
+		{"read": "DELETE FROM t1 WHERE a = 'hi'"},
+		{"write": "UPDATE t1 SET b = 'changed' WHERE a = 'how'"}
+	]}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr = raw(req)
+	assert.Equal(sqldErr.Code, http.StatusBadRequest)
+	assert.Contains(sqldErr.Error(), "statement 0:")
+
+	b = bytes.NewBufferString(`{"statements": [
+		{"read": "PRAGMA query_only = OFF"},
+		{"write": "UPDATE t1 SET b = 'changed' WHERE a = 'how'"}
+	]}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr = raw(req)
+	assert.Contains(sqldErr.Error(), "statement 0:")
+
+	b = bytes.NewBufferString(`{"read": "SELECT 1; DELETE FROM t1"}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr = raw(req)
+	assert.Contains(sqldErr.Error(), "single SELECT")
+
+	// Statements that describe the database are reads too
+	for _, sql := range []string{"PRAGMA table_info(t1)", "PRAGMA main.index_list(t1)", "EXPLAIN QUERY PLAN SELECT * FROM t1"} {
+		b = bytes.NewBufferString(`{"read": "` + sql + `"}`)
+		req, _ = http.NewRequest("POST", "http://example.com/", b)
+		data, sqldErr := raw(req)
+		assert.Nil(sqldErr)
+		assert.NotEmpty(data)
+	}
+	for _, sql := range []string{"PRAGMA query_only(0)", "EXPLAIN ANALYZE DELETE FROM t1"} {
+		b = bytes.NewBufferString(`{"read": "` + sql + `"}`)
+		req, _ = http.NewRequest("POST", "http://example.com/", b)
+		_, sqldErr = raw(req)
+		assert.Contains(sqldErr.Error(), "single SELECT")
+	}
+
+	var count int
+	db.Get(&count, "SELECT COUNT(*) FROM t1")
+	assert.Equal(count, 2)
+
+	// The connection must be writable again once the read is done
+	b = bytes.NewBufferString(`{"write": "DELETE FROM t1 WHERE a = 'hi'"}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr = raw(req)
+	assert.Nil(sqldErr)
+
+	b = bytes.NewBufferString(`{"read": "SELECT * FROM t1"}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	req.Header.Set(txHeader, "abc")
+	_, sqldErr = raw(req)
+	assert.Equal(sqldErr.Code, http.StatusNotFound)
+
+	// Reads only join transactions that cannot write
+	id, _ := beginTx(false)
+	for _, body := range []string{
+		`{"read": "SELECT * FROM t1"}`,
+		`{"statements": [{"read": "SELECT * FROM t1"}]}`,
+		`{"statements": [{"write": "DELETE FROM t1"}, {"read": "SELECT * FROM t1"}]}`,
+	} {
+		req, _ = http.NewRequest("POST", "http://example.com/", bytes.NewBufferString(body))
+		req.Header.Set(txHeader, id)
+		_, sqldErr = raw(req)
+		assert.Equal(sqldErr.Code, http.StatusBadRequest)
+		assert.Contains(sqldErr.Error(), "read_only")
+	}
+	tx, _ := acquireTx(id)
+	tx.finish(false)
+	tx.Unlock()
+
+	req, _ = http.NewRequest("POST", "http://example.com/_tx?read_only=true", nil)
+	data, _ := handleTx(req)
+	id = data.(map[string]interface{})["id"].(string)
+
+	b = bytes.NewBufferString(`{"statements": [{"read": "SELECT * FROM t1"}, {"read": "SELECT 1"}]}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	req.Header.Set(txHeader, id)
+	data, sqldErr = raw(req)
+	assert.Nil(sqldErr)
+	assert.Len(data.([]interface{})[0], 1)
+
+	b = bytes.NewBufferString(`{"write": "DELETE FROM t1"}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	req.Header.Set(txHeader, id)
+	_, sqldErr = raw(req)
+	assert.Contains(sqldErr.Error(), "readonly")
+
+	tx, _ = acquireTx(id)
+	assert.Nil(tx.finish(true))
+	tx.Unlock()
+
+	db.Get(&count, "SELECT COUNT(*) FROM t1")
+	assert.Equal(count, 1)
+
+	// and the connection is writable again afterwards
+	_, err := db.Exec("DELETE FROM t1")
+	assert.Nil(err)
+}
+
+func TestRawRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	createDB()
+	defer closeDB()
+
+	dir, _ := ioutil.TempDir("", "sqld")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "registry.json")
+	ioutil.WriteFile(path, []byte(`[
+		{"read": "SELECT * FROM t1 WHERE a = ?"},
+		{"write": "DELETE FROM t1 WHERE a = :a"}
+	]`), 0644)
+
+	var err error
+	rawRegistry, err = loadRawRegistry(path)
+	assert.Nil(err)
+	assert.Len(rawRegistry, 2)
+	defer func() { rawRegistry = make(map[string]RawQuery) }()
+
+	readHash := queryHash("SELECT * FROM t1 WHERE a = ?")
+	b := bytes.NewBufferString(`{"hash": "` + readHash + `", "args": ["hi"]}`)
+	req, _ := http.NewRequest("POST", "http://example.com/", b)
+	data, sqldErr := raw(req)
+	assert.Nil(sqldErr)
+	assert.Len(data.([]map[string]interface{}), 1)
+
+	b = bytes.NewBufferString(`{"hash": "abc"}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr = raw(req)
+	assert.Equal(sqldErr.Code, http.StatusNotFound)
+
+	*rawStrict = true
+	defer func() { *rawStrict = false }()
+
+	b = bytes.NewBufferString(`{"read": "SELECT * FROM t1"}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr = raw(req)
+	assert.Equal(sqldErr.Code, http.StatusForbidden)
+
+	b = bytes.NewBufferString(`{"write": "SELECT * FROM t1 WHERE a = ?", "args": ["hi"]}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr = raw(req)
+	assert.Equal(sqldErr.Code, http.StatusForbidden)
+
+	b = bytes.NewBufferString(`{"read": "SELECT * FROM t1 WHERE a = ?", "args": ["hi"]}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr = raw(req)
+	assert.Nil(sqldErr)
+
+	writeHash := queryHash("DELETE FROM t1 WHERE a = :a")
+	b = bytes.NewBufferString(`{"statements": [
+		{"hash": "` + writeHash + `", "params": {"a": "hi"}},
+		{"read": "SELECT * FROM t1"}
+	]}`)
+	req, _ = http.NewRequest("POST", "http://example.com/", b)
+	_, sqldErr = raw(req)
+	assert.Equal(sqldErr.Code, http.StatusForbidden)
+	assert.Contains(sqldErr.Error(), "statement 1:")
+
+	ioutil.WriteFile(path, []byte(`[{"read": "SELECT 1", "write": "SELECT 2"}]`), 0644)
+	_, err = loadRawRegistry(path)
+	assert.Contains(err.Error(), "query 0")
+}
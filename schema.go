@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sync"
+)
+
+var columnCache sync.Map
+
+// tableSchema is the cached column information of a table.
+type tableSchema struct {
+	names []string
+	kinds map[string]string
+}
+
+// loadTableSchema returns the columns of a table. Results are cached for
+// the lifetime of the process.
+func loadTableSchema(table string) (*tableSchema, error) {
+	if schema, ok := columnCache.Load(table); ok {
+		return schema.(*tableSchema), nil
+	}
+
+	sql, args, err := sq.Select("*").From(table).Limit(0).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &tableSchema{
+		names: make([]string, len(types)),
+		kinds: make(map[string]string, len(types)),
+	}
+	for i, ct := range types {
+		schema.names[i] = ct.Name()
+		schema.kinds[ct.Name()] = columnKind(ct)
+	}
+	columnCache.Store(table, schema)
+	return schema, nil
+}
+
+// tableColumns returns the column names of a table, in table order.
+func tableColumns(table string) ([]string, error) {
+	schema, err := loadTableSchema(table)
+	if err != nil {
+		return nil, err
+	}
+	return schema.names, nil
+}
+
+// hasColumn reports whether table has a column with the given name.
+func hasColumn(table, column string) bool {
+	cols, err := tableColumns(table)
+	if err != nil {
+		return false
+	}
+	for _, c := range cols {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// foreignKey is a column referencing a column of another table.
+type foreignKey struct {
+	Table  string `db:"ref_table"`
+	Column string `db:"ref_column"`
+}
+
+var foreignKeyCache sync.Map
+
+// foreignKeyQueries look up the foreign keys of a table, returning the
+// referencing column and the table and column it references.
+var foreignKeyQueries = map[string]string{
+	"postgres": `SELECT kcu.column_name AS col, ccu.table_name AS ref_table, ccu.column_name AS ref_column
+FROM information_schema.table_constraints tc
+JOIN information_schema.key_column_usage kcu
+  ON kcu.constraint_name = tc.constraint_name AND kcu.table_schema = tc.table_schema
+JOIN information_schema.constraint_column_usage ccu
+  ON ccu.constraint_name = tc.constraint_name AND ccu.table_schema = tc.table_schema
+WHERE tc.constraint_type = 'FOREIGN KEY' AND tc.table_name = ?`,
+	"mysql": `SELECT COLUMN_NAME AS col, REFERENCED_TABLE_NAME AS ref_table, REFERENCED_COLUMN_NAME AS ref_column
+FROM information_schema.KEY_COLUMN_USAGE
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND REFERENCED_TABLE_NAME IS NOT NULL`,
+	"sqlite3": `SELECT "from" AS col, "table" AS ref_table, COALESCE("to", 'id') AS ref_column
+FROM pragma_foreign_key_list(?)`,
+}
+
+// foreignKeys returns the foreign keys of a table keyed by the referencing
+// column. Results are cached for the lifetime of the process.
+func foreignKeys(table string) (map[string]foreignKey, error) {
+	if fks, ok := foreignKeyCache.Load(table); ok {
+		return fks.(map[string]foreignKey), nil
+	}
+
+	var rows []struct {
+		Column string `db:"col"`
+		foreignKey
+	}
+	if err := db.Select(&rows, db.Rebind(foreignKeyQueries[*dbtype]), table); err != nil {
+		return nil, err
+	}
+
+	fks := make(map[string]foreignKey, len(rows))
+	for _, row := range rows {
+		fks[row.Column] = row.foreignKey
+	}
+	foreignKeyCache.Store(table, fks)
+	return fks, nil
+}
+
+// tableQueries list the tables of the database.
+var tableQueries = map[string]string{
+	"postgres": `SELECT table_name FROM information_schema.tables
+WHERE table_schema = current_schema() AND table_type = 'BASE TABLE' ORDER BY table_name`,
+	"mysql": `SELECT table_name FROM information_schema.tables
+WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE' ORDER BY table_name`,
+	"sqlite3": `SELECT name FROM sqlite_master
+WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`,
+}
+
+// listTables returns the names of the tables in the database.
+func listTables() ([]string, error) {
+	var tables []string
+	err := db.Select(&tables, tableQueries[*dbtype])
+	return tables, err
+}
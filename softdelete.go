@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// restoreRoute is the url path, relative to the url prefix, that
+	// restores soft-deleted rows
+	restoreRoute = "_restore"
+
+	// adminHeader is the request header carrying the admin token
+	adminHeader = "X-Sqld-Admin-Token"
+
+	defaultSoftDeleteColumn = "deleted_at"
+)
+
+// softDeleteColumn returns the column marking soft-deleted rows of table,
+// or an empty string when rows of table are deleted for real. Tables are
+// configured with the soft-delete flag as "table" or "table:column".
+func softDeleteColumn(table string) string {
+	for _, entry := range strings.Split(*softDelete, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+		if parts[0] != table || table == "" {
+			continue
+		}
+		if len(parts) == 2 && parts[1] != "" {
+			return parts[1]
+		}
+		return defaultSoftDeleteColumn
+	}
+	return ""
+}
+
+// privileged reports whether the request carries the admin token.
+func privileged(r *http.Request) bool {
+	token := r.Header.Get(adminHeader)
+	return *adminToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(*adminToken)) == 1
+}
+
+// withDeleted reports whether a request asks to include soft-deleted rows.
+func withDeleted(r *http.Request) bool {
+	return r.URL.Query().Get("__with_deleted__") == "true"
+}
+
+// softDeleteCondition returns the WHERE clause hiding soft-deleted rows of
+// table, or nil when the table is not soft deleted or the request asks for
+// deleted rows.
+func softDeleteCondition(r *http.Request, table string) squirrel.Sqlizer {
+	col := softDeleteColumn(table)
+	if col == "" || withDeleted(r) {
+		return nil
+	}
+	return squirrel.Eq{col: nil}
+}
+
+// buildSoftDeleteQuery builds the UPDATE that marks the rows targeted by a
+// DELETE request as deleted.
+func buildSoftDeleteQuery(r *http.Request, column string) (string, []interface{}, error) {
+	table, args, id := parseRequest(r)
+	query := sq.Update(table).
+		Set(column, time.Now().UTC()).
+		Where(squirrel.Eq{column: nil})
+
+	if id != "" {
+		query = query.Where(squirrel.Eq{"id": id})
+	}
+
+	if cond := versionCondition(r, table); cond != nil {
+		query = query.Where(cond)
+	}
+
+	for key, val := range args {
+		switch key {
+		case "__limit__":
+			limit, err := strconv.Atoi(val[0])
+			if err == nil {
+				query = query.Limit(uint64(limit))
+			}
+		case "__all__", "__explain__":
+		default:
+			query = query.Where(squirrel.Eq{key: val})
+		}
+	}
+
+	return query.ToSql()
+}
+
+// restore handles POST {url}_restore/:table/:id, clearing the soft-delete
+// column of the matching rows. Only privileged callers may restore rows.
+func restore(r *http.Request) (interface{}, *SqldError) {
+	if r.Method != "POST" {
+		return nil, NewError(nil, http.StatusMethodNotAllowed)
+	}
+	if !privileged(r) {
+		return nil, Forbidden(errors.New("restoring rows requires the admin token"))
+	}
+
+	paths := routePaths(r)
+	if len(paths) < 2 || len(paths) > 3 {
+		return nil, NotFound(nil)
+	}
+
+	table := paths[1]
+	col := softDeleteColumn(table)
+	if col == "" {
+		return nil, BadRequest(errors.New(table + " is not soft deleted"))
+	}
+
+	query := sq.Update(table).
+		Set(col, nil).
+		Where(squirrel.NotEq{col: nil})
+	target := sq.Select("*").From(table).Where(squirrel.NotEq{col: nil})
+
+	if len(paths) == 3 {
+		query = query.Where(squirrel.Eq{"id": paths[2]})
+		target = target.Where(squirrel.Eq{"id": paths[2]})
+	}
+
+	filters := r.URL.Query()
+	delete(filters, "__all__")
+	for key, val := range filters {
+		query = query.Where(squirrel.Eq{key: val})
+		target = target.Where(squirrel.Eq{key: val})
+	}
+
+	if len(paths) == 2 && len(filters) == 0 && !allowAllRows(r, table) {
+		return nil, BadRequest(errMassWrite)
+	}
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+	// Restored rows reappear to subscribers as if they were inserted. The
+	// url names the restore route rather than the table, so If-Match,
+	// which is checked against the rows a url reads, does not apply.
+	write := selectWrite(table, "insert", target, map[string]interface{}{col: nil})
+	return withTx(r, func(ext sqlx.Ext) (interface{}, *SqldError) {
+		_, err := applyWrite(ext, sql, args, write)
+		return nil, err
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSoftDeleteColumn(t *testing.T) {
+	assert := assert.New(t)
+
+	*softDelete = "users, orders:removed_at,"
+	defer func() { *softDelete = "" }()
+
+	assert.Equal(softDeleteColumn("users"), "deleted_at")
+	assert.Equal(softDeleteColumn("orders"), "removed_at")
+	assert.Equal(softDeleteColumn("events"), "")
+	assert.Equal(softDeleteColumn(""), "")
+}
+
+func TestBuildSoftDeleteQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	*softDelete = "user"
+	defer func() { *softDelete = "" }()
+
+	req, _ := http.NewRequest("DELETE", "http://example.com/user/8", nil)
+	sql, args, err := buildDeleteQuery(req)
+	assert.Nil(err)
+	assert.Len(args, 2)
+	assert.Equal(args[1], "8")
+	assert.Equal(sql, "UPDATE user SET deleted_at = ? WHERE deleted_at IS NULL AND id = ?")
+
+	req, _ = http.NewRequest("GET", "http://example.com/user?__with_deleted__=true", nil)
+	sql, _, err = buildSelectQuery(req)
+	assert.Nil(err)
+	assert.Equal(sql, "SELECT * FROM user")
+
+	req, _ = http.NewRequest("GET", "http://example.com/user", nil)
+	sql, _, err = buildSelectQuery(req)
+	assert.Nil(err)
+	assert.Equal(sql, "SELECT * FROM user WHERE deleted_at IS NULL")
+}
+
+func TestSoftDelete(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+	db.MustExec("CREATE TABLE docs (id INTEGER PRIMARY KEY, name TEXT, deleted_at DATETIME)")
+	db.MustExec("INSERT INTO docs (name) VALUES ('kept'), ('gone')")
+
+	*softDelete = "docs"
+	*adminToken = "secret"
+	defer func() {
+		*softDelete = ""
+		*adminToken = ""
+	}()
+
+	count := func(target string, admin bool) int {
+		req, _ := http.NewRequest("GET", target, nil)
+		if admin {
+			req.Header.Set(adminHeader, "secret")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var rows []map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &rows)
+		return len(rows)
+	}
+
+	req, _ := http.NewRequest("DELETE", "http://example.com/docs/2", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusNoContent)
+
+	var total int
+	db.Get(&total, "SELECT COUNT(*) FROM docs")
+	assert.Equal(total, 2)
+	assert.Equal(count("http://example.com/docs", false), 1)
+	assert.Equal(count("http://example.com/docs?__with_deleted__=true", true), 2)
+
+	req, _ = http.NewRequest("GET", "http://example.com/docs?__with_deleted__=true", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusForbidden)
+
+	req, _ = http.NewRequest("DELETE", "http://example.com/docs/2", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusNotFound)
+
+	req, _ = http.NewRequest("PUT", "http://example.com/docs/2", bytes.NewBufferString(`{"name": "back"}`))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusNotFound)
+
+	req, _ = http.NewRequest("POST", "http://example.com/_restore/docs/2", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusForbidden)
+
+	req, _ = http.NewRequest("POST", "http://example.com/_restore/docs/2", nil)
+	req.Header.Set(adminHeader, "secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusNoContent)
+	assert.Equal(count("http://example.com/docs", false), 2)
+
+	req, _ = http.NewRequest("DELETE", "http://example.com/docs/2", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusNoContent)
+
+	req, _ = http.NewRequest("POST", "http://example.com/_restore/docs/2", nil)
+	req.Header.Set(adminHeader, "secret")
+	req.Header.Set("If-Match", `"abc"`)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusNoContent)
+
+	req, _ = http.NewRequest("POST", "http://example.com/_restore/t1", nil)
+	req.Header.Set(adminHeader, "secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusBadRequest)
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
@@ -35,6 +37,24 @@ var (
 	port     = flag.Int("port", 8080, "http port")
 	url      = flag.String("url", "/", "url prefix")
 
+	versionColumn   = flag.String("version-column", "", "column holding row versions used for ETags")
+	softDelete      = flag.String("soft-delete", "", "comma separated tables, as table or table:column, whose rows are soft deleted")
+	adminToken      = flag.String("admin-token", "", "token allowing access to soft-deleted rows")
+	allowAll        = flag.String("allow-all", "", "comma separated tables that may be updated or deleted without a filter")
+	maxAffected     = flag.Int64("max-affected", 0, "most rows a single update or delete may change, 0 for no limit")
+	queriesDir      = flag.String("queries", "", "directory of .sql files served as saved queries")
+	policyFile      = flag.String("raw-policy", "", "JSON file of rules limiting the statements raw queries may run")
+	registryFile    = flag.String("raw-registry", "", "JSON file of raw queries clients may run by hash")
+	rawStrict       = flag.Bool("raw-strict", false, "only allow raw queries from the raw registry")
+	compressMin     = flag.Int("compress-min", 1024, "smallest response, in bytes, that is compressed, -1 to never compress")
+	maxDecompressed = flag.Int64("max-decompressed", 256<<20, "largest gzip request body, in bytes, once decompressed, 0 for no limit")
+	grpcPort        = flag.Int("grpc-port", 0, "gRPC port, 0 to serve only http")
+	changeLogSize   = flag.Int("changes", 0, "recent row changes kept for subscribers to resume from, 0 to disable change subscriptions")
+	notifyChannel   = flag.String("notify-channel", "", "Postgres channel triggers send row changes on")
+
+	txTimeout      = flag.Duration("tx-timeout", 30*time.Second, "idle time before an open transaction is rolled back")
+	idempotencyTTL = flag.Duration("idempotency-ttl", 24*time.Hour, "how long idempotency keys are remembered, 0 to keep them forever")
+
 	mysqlDSNTemplate    = "%s:%s@(%s)/%s?parseTime=true"
 	postgresDSNTemplate = "postgres://%s:%s@%s/%s?sslmode=disable"
 
@@ -42,10 +62,19 @@ var (
 	sq squirrel.StatementBuilderType
 )
 
-// RawQuery wraps the request body of a raw sqld request
+// RawQuery wraps the request body of a raw sqld request. Values for
+// placeholders are given either positionally in Args, matching `?`
+// placeholders, or by name in Params, matching `:name` placeholders.
+// Statements holds a script of queries run in order in one transaction.
+// Hash names a registered query to run in place of ReadQuery or
+// WriteQuery.
 type RawQuery struct {
-	ReadQuery  string `json:"read"`
-	WriteQuery string `json:"write"`
+	ReadQuery  string                 `json:"read"`
+	WriteQuery string                 `json:"write"`
+	Hash       string                 `json:"hash"`
+	Args       []interface{}          `json:"args"`
+	Params     map[string]interface{} `json:"params"`
+	Statements []RawQuery             `json:"statements"`
 }
 
 // SqldError provides additional information on errors encountered
@@ -65,6 +94,9 @@ func NewError(err error, code int) *SqldError {
 	if err == nil {
 		err = errors.New("")
 	}
+	if errors.Is(err, errBodyTooLarge) {
+		code = http.StatusRequestEntityTooLarge
+	}
 	return &SqldError{
 		Code: code,
 		Err:  err,
@@ -81,6 +113,23 @@ func NotFound(err error) *SqldError {
 	return NewError(err, http.StatusNotFound)
 }
 
+// Forbidden builds a SqldError that represents a forbidden request
+func Forbidden(err error) *SqldError {
+	return NewError(err, http.StatusForbidden)
+}
+
+// Conflict builds a SqldError that represents a conflict with the current
+// state of the database
+func Conflict(err error) *SqldError {
+	return NewError(err, http.StatusConflict)
+}
+
+// PreconditionFailed builds a SqldError that represents a failed
+// If-Match precondition
+func PreconditionFailed(err error) *SqldError {
+	return NewError(err, http.StatusPreconditionFailed)
+}
+
 // InternalError builds a SqldError that represents an internal error
 func InternalError(err error) *SqldError {
 	return NewError(err, http.StatusInternalServerError)
@@ -156,6 +205,12 @@ func closeDB() error {
 	return nil
 }
 
+// routePaths splits the request path, relative to the url prefix, into
+// its segments.
+func routePaths(r *http.Request) []string {
+	return strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, *url), "/"), "/")
+}
+
 func parseRequest(r *http.Request) (string, map[string][]string, string) {
 	paths := strings.Split(strings.TrimPrefix(r.URL.Path, *url), "/")
 	table := paths[0]
@@ -174,6 +229,10 @@ func buildSelectQuery(r *http.Request) (string, []interface{}, error) {
 		query = query.Where(squirrel.Eq{"id": id})
 	}
 
+	if cond := softDeleteCondition(r, table); cond != nil {
+		query = query.Where(cond)
+	}
+
 	for key, val := range args {
 		switch key {
 		case "__limit__":
@@ -188,6 +247,7 @@ func buildSelectQuery(r *http.Request) (string, []interface{}, error) {
 			}
 		case "__order_by__":
 			query = query.OrderBy(val...)
+		case "__with_deleted__", "__explain__", "__format__", "__stream__", "__all__":
 		default:
 			query = query.Where(squirrel.Eq{key: val})
 		}
@@ -204,10 +264,22 @@ func buildUpdateQuery(r *http.Request, values map[string]interface{}) (string, [
 		query = query.SetMap(squirrel.Eq{key: val})
 	}
 
+	if _, ok := values[*versionColumn]; !ok && versioned(table) {
+		query = query.Set(*versionColumn, squirrel.Expr(*versionColumn+" + 1"))
+	}
+
 	if id != "" {
 		query = query.Where(squirrel.Eq{"id": id})
 	}
 
+	if cond := versionCondition(r, table); cond != nil {
+		query = query.Where(cond)
+	}
+
+	if col := softDeleteColumn(table); col != "" {
+		query = query.Where(squirrel.Eq{col: nil})
+	}
+
 	for key, val := range args {
 		switch key {
 		case "__limit__":
@@ -215,6 +287,7 @@ func buildUpdateQuery(r *http.Request, values map[string]interface{}) (string, [
 			if err == nil {
 				query = query.Limit(uint64(limit))
 			}
+		case "__all__", "__explain__":
 		default:
 			query = query.Where(squirrel.Eq{key: val})
 		}
@@ -225,12 +298,20 @@ func buildUpdateQuery(r *http.Request, values map[string]interface{}) (string, [
 
 func buildDeleteQuery(r *http.Request) (string, []interface{}, error) {
 	table, args, id := parseRequest(r)
+	if col := softDeleteColumn(table); col != "" {
+		return buildSoftDeleteQuery(r, col)
+	}
+
 	query := sq.Delete("").From(table)
 
 	if id != "" {
 		query = query.Where(squirrel.Eq{"id": id})
 	}
 
+	if cond := versionCondition(r, table); cond != nil {
+		query = query.Where(cond)
+	}
+
 	for key, val := range args {
 		switch key {
 		case "__limit__":
@@ -238,6 +319,7 @@ func buildDeleteQuery(r *http.Request) (string, []interface{}, error) {
 			if err == nil {
 				query = query.Limit(uint64(limit))
 			}
+		case "__all__", "__explain__":
 		default:
 			query = query.Where(squirrel.Eq{key: val})
 		}
@@ -246,17 +328,18 @@ func buildDeleteQuery(r *http.Request) (string, []interface{}, error) {
 	return query.ToSql()
 }
 
-func readQuery(sql string, args []interface{}) ([]map[string]interface{}, error) {
-	rows, err := db.Query(sql, args...)
+func readQuery(q sqlx.Queryer, sql string, args []interface{}) ([]map[string]interface{}, error) {
+	rows, err := q.Query(sql, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	columns, err := rows.Columns()
+	columns, err := rows.ColumnTypes()
 	if err != nil {
 		return nil, err
 	}
+	kinds := columnKindsOf(columns)
 
 	count := len(columns)
 	var tableData []map[string]interface{}
@@ -273,16 +356,7 @@ func readQuery(sql string, args []interface{}) ([]map[string]interface{}, error)
 		}
 		rowData := make(map[string]interface{})
 		for i, col := range columns {
-			val := values[i]
-			b, ok := val.([]byte)
-
-			var v interface{}
-			if ok {
-				v = string(b)
-			} else {
-				v = val
-			}
-			rowData[col] = v
+			rowData[col.Name()] = columnValue(kinds[i], values[i])
 		}
 		tableData = append(tableData, rowData)
 	}
@@ -296,12 +370,35 @@ func readQuery(sql string, args []interface{}) ([]map[string]interface{}, error)
 
 // read handles the GET request.
 func read(r *http.Request) (interface{}, *SqldError) {
+	if withDeleted(r) && !privileged(r) {
+		return nil, Forbidden(errors.New("__with_deleted__ requires the admin token"))
+	}
+
 	sql, args, err := buildSelectQuery(r)
 	if err != nil {
 		return nil, BadRequest(err)
 	}
 
-	tableData, err := readQuery(sql, args)
+	if explainMode(r) != "" {
+		return explain(r, sql, args)
+	}
+
+	format, sqldErr := requestedRowFormat(r)
+	if sqldErr != nil {
+		return nil, sqldErr
+	}
+
+	ext, release, sqldErr := executor(r)
+	if sqldErr != nil {
+		return nil, sqldErr
+	}
+	if format != nil {
+		table, _, _ := parseRequest(r)
+		return streamQuery(r, ext, format, table, sql, args, release)
+	}
+	defer release()
+
+	tableData, err := readQuery(ext, sql, args)
 	if err != nil {
 		return nil, InternalError(err)
 	}
@@ -310,7 +407,7 @@ func read(r *http.Request) (interface{}, *SqldError) {
 
 // createSingle handles the POST method when only a single model
 // is provided in the request body.
-func createSingle(table string, item map[string]interface{}) (map[string]interface{}, error) {
+func createSingle(e sqlx.Execer, table string, item map[string]interface{}) (map[string]interface{}, error) {
 	columns := make([]string, len(item))
 	values := make([]interface{}, len(item))
 
@@ -327,7 +424,7 @@ func createSingle(table string, item map[string]interface{}) (map[string]interfa
 
 	sql, args, err := query.ToSql()
 
-	res, err := db.Exec(sql, args...)
+	res, err := e.Exec(sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -336,11 +433,17 @@ func createSingle(table string, item map[string]interface{}) (map[string]interfa
 		return nil, err
 	}
 	item["id"] = id
+	recordChange(e, &change{Table: table, Op: "insert", Row: item})
 	return item, nil
 }
 
 // create handles the POST method.
 func create(r *http.Request) (interface{}, *SqldError) {
+	if format := importFormat(r); format != "" {
+		table, _, _ := parseRequest(r)
+		return importRows(r, table, format)
+	}
+
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return nil, BadRequest(err)
@@ -348,15 +451,25 @@ func create(r *http.Request) (interface{}, *SqldError) {
 	defer r.Body.Close()
 
 	var data interface{}
-	if err := json.Unmarshal(body, &data); err != nil {
-		return nil, BadRequest(err)
+	if err := decodeBody(r, body, &data); err != nil {
+		return nil, err
 	}
 
 	table, _, _ := parseRequest(r)
 
 	item, ok := data.(map[string]interface{})
 	if ok {
-		saved, err := createSingle(table, item)
+		if key := r.Header.Get(idempotencyHeader); key != "" {
+			return createIdempotent(r, key, body, table, item)
+		}
+
+		ext, release, sqldErr := executor(r)
+		if sqldErr != nil {
+			return nil, sqldErr
+		}
+		defer release()
+
+		saved, err := createSingle(ext, table, item)
 		if err != nil {
 			return nil, InternalError(err)
 		}
@@ -368,6 +481,10 @@ func create(r *http.Request) (interface{}, *SqldError) {
 
 // update handles the PUT method.
 func update(r *http.Request) (interface{}, *SqldError) {
+	if err := guardMassWrite(r); err != nil {
+		return nil, err
+	}
+
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return nil, BadRequest(err)
@@ -375,8 +492,8 @@ func update(r *http.Request) (interface{}, *SqldError) {
 	defer r.Body.Close()
 
 	var data map[string]interface{}
-	if err := json.Unmarshal(body, &data); err != nil {
-		return nil, BadRequest(err)
+	if err := decodeBody(r, body, &data); err != nil {
+		return nil, err
 	}
 
 	sql, args, err := buildUpdateQuery(r, data)
@@ -385,38 +502,95 @@ func update(r *http.Request) (interface{}, *SqldError) {
 		return nil, BadRequest(err)
 	}
 
-	return execQuery(sql, args)
+	if explainMode(r) != "" {
+		return explain(r, sql, args)
+	}
+
+	return execWrite(r, sql, args, requestWrite(r, data))
 }
 
 // del handles the DELETE method.
 func del(r *http.Request) (interface{}, *SqldError) {
+	if err := guardMassWrite(r); err != nil {
+		return nil, err
+	}
+
 	sql, args, err := buildDeleteQuery(r)
 
 	if err != nil {
 		return nil, BadRequest(err)
 	}
 
-	return execQuery(sql, args)
+	if explainMode(r) != "" {
+		return explain(r, sql, args)
+	}
+
+	return execWrite(r, sql, args, requestWrite(r, nil))
 }
 
-// execQuery will perform a sql query, return the appropriate error code
-// given error states or return an http 204 NO CONTENT on success.
-func execQuery(sql string, args []interface{}) (interface{}, *SqldError) {
-	res, err := db.Exec(sql, args...)
+// execWrite runs an update or delete built from the request inside a
+// transaction, enforcing any If-Match precondition. The rows w describes
+// are published to change subscribers once the write commits.
+func execWrite(r *http.Request, sql string, args []interface{}, w *rowWrite) (interface{}, *SqldError) {
+	_, err := writeAffected(r, sql, args, w)
+	return nil, err
+}
+
+// writeAffected runs a write like execWrite and returns the number of rows
+// it changed.
+func writeAffected(r *http.Request, sql string, args []interface{}, w *rowWrite) (int64, *SqldError) {
+	var rows int64
+	_, err := withTx(r, func(ext sqlx.Ext) (interface{}, *SqldError) {
+		if err := checkIfMatch(ext, r); err != nil {
+			return nil, err
+		}
+
+		var err *SqldError
+		rows, err = applyWrite(ext, sql, args, w)
+		if err != nil && err.Code == http.StatusNotFound && r.Header.Get("If-Match") != "" {
+			return nil, PreconditionFailed(nil)
+		}
+		return nil, err
+	})
+	return rows, err
+}
+
+// applyWrite runs an update or delete, recording the rows w describes as
+// changed. Writes that change no rows fail with a not found error.
+func applyWrite(ext sqlx.Ext, sql string, args []interface{}, w *rowWrite) (int64, *SqldError) {
+	changed, err := w.before(ext)
 	if err != nil {
-		return nil, BadRequest(err)
+		return 0, InternalError(err)
 	}
 
-	rows, err := res.RowsAffected()
+	rows, sqldErr := execAffected(ext, sql, args)
+	if sqldErr != nil {
+		return 0, sqldErr
+	}
+	if rows == 0 {
+		return 0, NotFound(nil)
+	}
+	w.after(ext, changed)
+	return rows, nil
+}
+
+// execAffected runs a write and returns the number of rows it changed,
+// refusing writes that change more than max-affected rows.
+func execAffected(e sqlx.Execer, sql string, args []interface{}) (int64, *SqldError) {
+	res, err := e.Exec(sql, args...)
 	if err != nil {
-		return nil, BadRequest(err)
+		return 0, BadRequest(err)
 	}
 
-	if res != nil && rows == 0 {
-		return nil, NotFound(err)
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, BadRequest(err)
 	}
 
-	return nil, nil
+	if *maxAffected > 0 && rows > *maxAffected {
+		return 0, BadRequest(fmt.Errorf("%d rows affected exceeds the limit of %d", rows, *maxAffected))
+	}
+	return rows, nil
 }
 
 func raw(r *http.Request) (interface{}, *SqldError) {
@@ -426,21 +600,85 @@ func raw(r *http.Request) (interface{}, *SqldError) {
 	}
 	defer r.Body.Close()
 
+	c, sqldErr := requestCodec(r)
+	if sqldErr != nil {
+		return nil, sqldErr
+	}
+
 	var query RawQuery
-	err = json.Unmarshal(body, &query)
+	if c == jsonCodec {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		err = decoder.Decode(&query)
+	} else {
+		err = c.unmarshal(body, &query)
+	}
 	if err != nil {
 		return nil, BadRequest(err)
 	}
 
-	var noArgs []interface{}
+	return execRaw(r, query)
+}
+
+// execRaw runs a decoded raw query, script, or registered query.
+func execRaw(r *http.Request, query RawQuery) (interface{}, *SqldError) {
+	if err := resolveRaw(&query); err != nil {
+		return nil, err
+	}
+	for i := range query.Statements {
+		if err := resolveRaw(&query.Statements[i]); err != nil {
+			return nil, NewError(fmt.Errorf("statement %d: %s", i, err), err.Code)
+		}
+	}
+
+	if len(query.Statements) > 0 {
+		return rawScript(r, query.Statements)
+	}
+
 	if query.ReadQuery != "" {
-		tableData, err := readQuery(query.ReadQuery, noArgs)
+		return rawRead(r, query)
+	}
+
+	ext, release, sqldErr := executor(r)
+	if sqldErr != nil {
+		return nil, sqldErr
+	}
+	defer release()
+
+	return runRaw(ext, query)
+}
+
+// runRaw runs a single raw read or write query, provided the raw policy
+// allows it. Raw writes are not published to change subscribers since the
+// rows they change are unknown.
+func runRaw(ext sqlx.Ext, query RawQuery) (interface{}, *SqldError) {
+	statement := query.ReadQuery
+	if statement == "" {
+		statement = query.WriteQuery
+	}
+	if err := rawPolicy.check(statement); err != nil {
+		return nil, Forbidden(err)
+	}
+
+	if query.ReadQuery != "" {
+		if sqldErr := checkRead(query.ReadQuery); sqldErr != nil {
+			return nil, sqldErr
+		}
+		sql, args, err := bindRaw(ext, query.ReadQuery, query)
+		if err != nil {
+			return nil, BadRequest(err)
+		}
+		tableData, err := readQuery(ext, sql, args)
 		if err != nil {
 			return nil, BadRequest(err)
 		}
 		return tableData, nil
 	} else if query.WriteQuery != "" {
-		res, err := db.Exec(query.WriteQuery, noArgs...)
+		sql, args, err := bindRaw(ext, query.WriteQuery, query)
+		if err != nil {
+			return nil, BadRequest(err)
+		}
+		res, err := ext.Exec(sql, args...)
 		if err != nil {
 			return nil, BadRequest(err)
 		}
@@ -474,16 +712,38 @@ func handleQuery(w http.ResponseWriter, r *http.Request) {
 		)
 	}
 
+	table, _, _ := parseRequest(r)
+
 	if r.URL.Path == "/" {
 		if *allowRaw == true && r.Method == "POST" {
 			data, err = raw(r)
 		} else {
 			err = BadRequest(nil)
 		}
+	} else if table == txRoute {
+		data, err = handleTx(r)
+		if data != nil {
+			status = http.StatusCreated
+		}
+	} else if table == restoreRoute {
+		data, err = restore(r)
+	} else if table == queriesRoute {
+		data, err = handleQueries(r)
+	} else if table == graphqlRoute {
+		data, err = handleGraphQL(r)
+	} else if table == changesRoute {
+		data, err = handleChanges(r)
 	} else {
 		switch r.Method {
 		case "GET":
-			data, err = read(r)
+			if wantsHTML(r) {
+				data, err = readHTML(r)
+			} else if wantsEvents(r) {
+				data, err = readEvents(r)
+			} else {
+				data, err = read(r)
+				setETag(w, r, data)
+			}
 		case "POST":
 			data, err = create(r)
 			status = http.StatusCreated
@@ -503,10 +763,37 @@ func handleQuery(w http.ResponseWriter, r *http.Request) {
 	} else if err != nil {
 		http.Error(w, err.Error(), err.Code)
 		logRequest(err.Code)
+	} else if page, ok := data.(*htmlPage); ok {
+		if err := writeHTML(w, page); err != nil {
+			log.Printf("Rendering %s failed: %s\n", r.URL.String(), err)
+		}
+		logRequest(http.StatusOK)
+	} else if stream, ok := data.(*changeStream); ok {
+		if err := stream.serveWebSocket(w, r); err != nil {
+			log.Printf("Subscription %s failed: %s\n", r.URL.String(), err)
+		}
+		logRequest(http.StatusSwitchingProtocols)
+	} else if stream, ok := data.(*eventStream); ok {
+		if err := stream.serve(w, r); err != nil {
+			log.Printf("Event stream %s failed: %s\n", r.URL.String(), err)
+		}
+		logRequest(http.StatusOK)
+	} else if rows, ok := data.(*resultRows); ok {
+		if err := writeRows(w, r, rows); err != nil {
+			log.Printf("Streaming %s failed: %s\n", r.URL.String(), err)
+		}
+		logRequest(http.StatusOK)
 	} else {
-		w.Header().Set("Content-Type", "application/json")
+		c := responseCodec(r)
+		b, err := c.marshal(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			logRequest(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", c.mediaType)
 		w.WriteHeader(status)
-		json.NewEncoder(w).Encode(data)
+		w.Write(b)
 		logRequest(http.StatusOK)
 	}
 }
@@ -523,7 +810,48 @@ func main() {
 		log.Fatalf("Unable to connect to database: %s\n", err)
 	}
 
-	http.HandleFunc(*url, handleQuery)
+	if *policyFile != "" {
+		rawPolicy, err = loadRawPolicy(*policyFile)
+		if err != nil {
+			log.Fatalf("Unable to load raw policy: %s\n", err)
+		}
+	}
+
+	if *registryFile != "" {
+		rawRegistry, err = loadRawRegistry(*registryFile)
+		if err != nil {
+			log.Fatalf("Unable to load raw registry: %s\n", err)
+		}
+	}
+
+	if *queriesDir != "" {
+		savedQueries, err = loadQueries(*queriesDir)
+		if err != nil {
+			log.Fatalf("Unable to load saved queries: %s\n", err)
+		}
+	}
+
+	if *notifyChannel != "" {
+		if *dbtype != "postgres" {
+			log.Fatal("-notify-channel needs a postgres database")
+		}
+		if err := listenChanges(buildDSN(), *notifyChannel); err != nil {
+			log.Fatalf("Unable to listen for changes: %s\n", err)
+		}
+	}
+
+	if *grpcPort > 0 {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", *grpcPort))
+		if err != nil {
+			log.Fatalf("Unable to listen for gRPC: %s\n", err)
+		}
+		log.Printf("sqld gRPC listening on port %d", *grpcPort)
+		go func() {
+			log.Print(newGRPCServer().Serve(lis))
+		}()
+	}
+
+	http.HandleFunc(*url, withCompression(handleQuery))
 	log.Printf("sqld listening on port %d", *port)
 	log.Print(http.ListenAndServe(fmt.Sprintf(":%d", *port), nil))
 }
@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 
 	"github.com/Masterminds/squirrel"
@@ -34,6 +35,9 @@ func createDB() {
 	*dbtype = "sqlite3"
 	*dsn = ":memory:"
 	db, sq, _ = initDB(sqlx.Connect)
+	// Each test gets a fresh database, so set it up again on first use
+	idempotencyOnce = sync.Once{}
+	graphqlOnce = sync.Once{}
 	db.MustExec("CREATE TABLE t1(a, b PRIMARY KEY)")
 	db.MustExec("INSERT INTO t1 (a, b) VALUES ('hi', 'there')")
 	db.MustExec("INSERT INTO t1 (a, b) VALUES ('how', 'dy')")
@@ -118,6 +122,22 @@ func TestNotFound(t *testing.T) {
 	assert.Equal(err.Error(), "not found")
 }
 
+func TestConflict(t *testing.T) {
+	assert := assert.New(t)
+
+	err := Conflict(errors.New("conflict"))
+	assert.Equal(err.Code, 409)
+	assert.Equal(err.Error(), "conflict")
+}
+
+func TestPreconditionFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	err := PreconditionFailed(errors.New("precondition failed"))
+	assert.Equal(err.Code, 412)
+	assert.Equal(err.Error(), "precondition failed")
+}
+
 func TestInternalError(t *testing.T) {
 	assert := assert.New(t)
 
@@ -265,7 +285,7 @@ func TestReadQuery(t *testing.T) {
 	defer closeDB()
 
 	var args []interface{}
-	data, err := readQuery("SELECT * FROM t1", args)
+	data, err := readQuery(db, "SELECT * FROM t1", args)
 	assert.Nil(err)
 	assert.Contains([]string{"hi", "how"}, data[0]["a"])
 	assert.Contains([]string{"there", "dy"}, data[0]["b"])
@@ -291,7 +311,7 @@ func TestCreateSingle(t *testing.T) {
 	createDB()
 	defer closeDB()
 
-	data, err := createSingle("t1", map[string]interface{}{
+	data, err := createSingle(db, "t1", map[string]interface{}{
 		"a": "boop",
 		"b": "doop",
 	})
@@ -402,15 +422,15 @@ func TestDel(t *testing.T) {
 	assert.Equal(data.B, "")
 }
 
-func TestExecQuery(t *testing.T) {
+func TestApplyWrite(t *testing.T) {
 	assert := assert.New(t)
 
 	createDB()
 	defer closeDB()
 
-	d, sqldErr := execQuery("UPDATE t1 SET b=? WHERE a=?", []interface{}{"doop", "hi"})
+	d, sqldErr := applyWrite(db, "UPDATE t1 SET b=? WHERE a=?", []interface{}{"doop", "hi"}, nil)
 	assert.Nil(sqldErr)
-	assert.Nil(d)
+	assert.Equal(d, int64(1))
 
 	data := TestData{}
 	err := db.Get(&data, "SELECT * FROM t1 WHERE a=?", "hi")
@@ -418,13 +438,13 @@ func TestExecQuery(t *testing.T) {
 	assert.Equal(data.A, "hi")
 	assert.Equal(data.B, "doop")
 
-	d, sqldErr = execQuery("UPDATE t1 SET b=? WHERE a=?", []interface{}{"doop", "not-in-the-db"})
+	d, sqldErr = applyWrite(db, "UPDATE t1 SET b=? WHERE a=?", []interface{}{"doop", "not-in-the-db"}, nil)
 	assert.Equal(sqldErr.Code, 404)
-	assert.Nil(d)
+	assert.Equal(d, int64(0))
 
-	d, sqldErr = execQuery("LET'S TRY OUT INCORRECT SQL", []interface{}{})
+	d, sqldErr = applyWrite(db, "LET'S TRY OUT INCORRECT SQL", []interface{}{}, nil)
 	assert.Equal(sqldErr.Code, 400)
-	assert.Nil(d)
+	assert.Equal(d, int64(0))
 }
 
 func TestRaw(t *testing.T) {
@@ -459,7 +479,7 @@ func TestRaw(t *testing.T) {
 	data, sqldErr = raw(req)
 
 	assert.Equal(sqldErr.Code, 400)
-	assert.Contains(sqldErr.Error(), "syntax error")
+	assert.Contains(sqldErr.Error(), "single SELECT")
 	assert.Nil(data)
 
 	b = bytes.NewBufferString(`{
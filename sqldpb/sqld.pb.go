@@ -0,0 +1,596 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v5.29.3
+// source: sqldpb/sqld.proto
+
+package sqldpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Filters match rows whose column equals the value, or any of the values
+// when given a list, like query string parameters do over HTTP.
+type QueryRequest struct {
+	state         protoimpl.MessageState     `protogen:"open.v1"`
+	Table         string                     `protobuf:"bytes,1,opt,name=table,proto3" json:"table,omitempty"`
+	Id            string                     `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Filters       map[string]*structpb.Value `protobuf:"bytes,3,rep,name=filters,proto3" json:"filters,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Limit         int64                      `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`
+	Offset        int64                      `protobuf:"varint,5,opt,name=offset,proto3" json:"offset,omitempty"`
+	OrderBy       []string                   `protobuf:"bytes,6,rep,name=order_by,json=orderBy,proto3" json:"order_by,omitempty"`
+	WithDeleted   bool                       `protobuf:"varint,7,opt,name=with_deleted,json=withDeleted,proto3" json:"with_deleted,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryRequest) Reset() {
+	*x = QueryRequest{}
+	mi := &file_sqldpb_sqld_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryRequest) ProtoMessage() {}
+
+func (x *QueryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sqldpb_sqld_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryRequest.ProtoReflect.Descriptor instead.
+func (*QueryRequest) Descriptor() ([]byte, []int) {
+	return file_sqldpb_sqld_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *QueryRequest) GetTable() string {
+	if x != nil {
+		return x.Table
+	}
+	return ""
+}
+
+func (x *QueryRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *QueryRequest) GetFilters() map[string]*structpb.Value {
+	if x != nil {
+		return x.Filters
+	}
+	return nil
+}
+
+func (x *QueryRequest) GetLimit() int64 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *QueryRequest) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *QueryRequest) GetOrderBy() []string {
+	if x != nil {
+		return x.OrderBy
+	}
+	return nil
+}
+
+func (x *QueryRequest) GetWithDeleted() bool {
+	if x != nil {
+		return x.WithDeleted
+	}
+	return false
+}
+
+type InsertRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Table         string                 `protobuf:"bytes,1,opt,name=table,proto3" json:"table,omitempty"`
+	Row           *structpb.Struct       `protobuf:"bytes,2,opt,name=row,proto3" json:"row,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InsertRequest) Reset() {
+	*x = InsertRequest{}
+	mi := &file_sqldpb_sqld_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InsertRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InsertRequest) ProtoMessage() {}
+
+func (x *InsertRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sqldpb_sqld_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InsertRequest.ProtoReflect.Descriptor instead.
+func (*InsertRequest) Descriptor() ([]byte, []int) {
+	return file_sqldpb_sqld_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *InsertRequest) GetTable() string {
+	if x != nil {
+		return x.Table
+	}
+	return ""
+}
+
+func (x *InsertRequest) GetRow() *structpb.Struct {
+	if x != nil {
+		return x.Row
+	}
+	return nil
+}
+
+type UpdateRequest struct {
+	state   protoimpl.MessageState     `protogen:"open.v1"`
+	Table   string                     `protobuf:"bytes,1,opt,name=table,proto3" json:"table,omitempty"`
+	Id      string                     `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Filters map[string]*structpb.Value `protobuf:"bytes,3,rep,name=filters,proto3" json:"filters,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Values  *structpb.Struct           `protobuf:"bytes,4,opt,name=values,proto3" json:"values,omitempty"`
+	Limit   int64                      `protobuf:"varint,5,opt,name=limit,proto3" json:"limit,omitempty"`
+	// all allows updating every row when there is no id or filter
+	All           bool `protobuf:"varint,6,opt,name=all,proto3" json:"all,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateRequest) Reset() {
+	*x = UpdateRequest{}
+	mi := &file_sqldpb_sqld_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateRequest) ProtoMessage() {}
+
+func (x *UpdateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sqldpb_sqld_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateRequest.ProtoReflect.Descriptor instead.
+func (*UpdateRequest) Descriptor() ([]byte, []int) {
+	return file_sqldpb_sqld_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *UpdateRequest) GetTable() string {
+	if x != nil {
+		return x.Table
+	}
+	return ""
+}
+
+func (x *UpdateRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *UpdateRequest) GetFilters() map[string]*structpb.Value {
+	if x != nil {
+		return x.Filters
+	}
+	return nil
+}
+
+func (x *UpdateRequest) GetValues() *structpb.Struct {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+func (x *UpdateRequest) GetLimit() int64 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *UpdateRequest) GetAll() bool {
+	if x != nil {
+		return x.All
+	}
+	return false
+}
+
+type DeleteRequest struct {
+	state   protoimpl.MessageState     `protogen:"open.v1"`
+	Table   string                     `protobuf:"bytes,1,opt,name=table,proto3" json:"table,omitempty"`
+	Id      string                     `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Filters map[string]*structpb.Value `protobuf:"bytes,3,rep,name=filters,proto3" json:"filters,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Limit   int64                      `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`
+	// all allows deleting every row when there is no id or filter
+	All           bool `protobuf:"varint,5,opt,name=all,proto3" json:"all,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteRequest) Reset() {
+	*x = DeleteRequest{}
+	mi := &file_sqldpb_sqld_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteRequest) ProtoMessage() {}
+
+func (x *DeleteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sqldpb_sqld_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRequest.ProtoReflect.Descriptor instead.
+func (*DeleteRequest) Descriptor() ([]byte, []int) {
+	return file_sqldpb_sqld_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *DeleteRequest) GetTable() string {
+	if x != nil {
+		return x.Table
+	}
+	return ""
+}
+
+func (x *DeleteRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *DeleteRequest) GetFilters() map[string]*structpb.Value {
+	if x != nil {
+		return x.Filters
+	}
+	return nil
+}
+
+func (x *DeleteRequest) GetLimit() int64 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *DeleteRequest) GetAll() bool {
+	if x != nil {
+		return x.All
+	}
+	return false
+}
+
+type WriteResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RowsAffected  int64                  `protobuf:"varint,1,opt,name=rows_affected,json=rowsAffected,proto3" json:"rows_affected,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WriteResult) Reset() {
+	*x = WriteResult{}
+	mi := &file_sqldpb_sqld_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WriteResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WriteResult) ProtoMessage() {}
+
+func (x *WriteResult) ProtoReflect() protoreflect.Message {
+	mi := &file_sqldpb_sqld_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WriteResult.ProtoReflect.Descriptor instead.
+func (*WriteResult) Descriptor() ([]byte, []int) {
+	return file_sqldpb_sqld_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *WriteResult) GetRowsAffected() int64 {
+	if x != nil {
+		return x.RowsAffected
+	}
+	return 0
+}
+
+// RawRequest mirrors the body of an HTTP raw query.
+type RawRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Read          string                 `protobuf:"bytes,1,opt,name=read,proto3" json:"read,omitempty"`
+	Write         string                 `protobuf:"bytes,2,opt,name=write,proto3" json:"write,omitempty"`
+	Hash          string                 `protobuf:"bytes,3,opt,name=hash,proto3" json:"hash,omitempty"`
+	Args          []*structpb.Value      `protobuf:"bytes,4,rep,name=args,proto3" json:"args,omitempty"`
+	Params        *structpb.Struct       `protobuf:"bytes,5,opt,name=params,proto3" json:"params,omitempty"`
+	Statements    []*RawRequest          `protobuf:"bytes,6,rep,name=statements,proto3" json:"statements,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RawRequest) Reset() {
+	*x = RawRequest{}
+	mi := &file_sqldpb_sqld_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RawRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RawRequest) ProtoMessage() {}
+
+func (x *RawRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sqldpb_sqld_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RawRequest.ProtoReflect.Descriptor instead.
+func (*RawRequest) Descriptor() ([]byte, []int) {
+	return file_sqldpb_sqld_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *RawRequest) GetRead() string {
+	if x != nil {
+		return x.Read
+	}
+	return ""
+}
+
+func (x *RawRequest) GetWrite() string {
+	if x != nil {
+		return x.Write
+	}
+	return ""
+}
+
+func (x *RawRequest) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *RawRequest) GetArgs() []*structpb.Value {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+func (x *RawRequest) GetParams() *structpb.Struct {
+	if x != nil {
+		return x.Params
+	}
+	return nil
+}
+
+func (x *RawRequest) GetStatements() []*RawRequest {
+	if x != nil {
+		return x.Statements
+	}
+	return nil
+}
+
+var File_sqldpb_sqld_proto protoreflect.FileDescriptor
+
+const file_sqldpb_sqld_proto_rawDesc = "" +
+	"\n" +
+	"\x11sqldpb/sqld.proto\x12\x04sqld\x1a\x1cgoogle/protobuf/struct.proto\"\xaf\x02\n" +
+	"\fQueryRequest\x12\x14\n" +
+	"\x05table\x18\x01 \x01(\tR\x05table\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\x129\n" +
+	"\afilters\x18\x03 \x03(\v2\x1f.sqld.QueryRequest.FiltersEntryR\afilters\x12\x14\n" +
+	"\x05limit\x18\x04 \x01(\x03R\x05limit\x12\x16\n" +
+	"\x06offset\x18\x05 \x01(\x03R\x06offset\x12\x19\n" +
+	"\border_by\x18\x06 \x03(\tR\aorderBy\x12!\n" +
+	"\fwith_deleted\x18\a \x01(\bR\vwithDeleted\x1aR\n" +
+	"\fFiltersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12,\n" +
+	"\x05value\x18\x02 \x01(\v2\x16.google.protobuf.ValueR\x05value:\x028\x01\"P\n" +
+	"\rInsertRequest\x12\x14\n" +
+	"\x05table\x18\x01 \x01(\tR\x05table\x12)\n" +
+	"\x03row\x18\x02 \x01(\v2\x17.google.protobuf.StructR\x03row\"\x9e\x02\n" +
+	"\rUpdateRequest\x12\x14\n" +
+	"\x05table\x18\x01 \x01(\tR\x05table\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\x12:\n" +
+	"\afilters\x18\x03 \x03(\v2 .sqld.UpdateRequest.FiltersEntryR\afilters\x12/\n" +
+	"\x06values\x18\x04 \x01(\v2\x17.google.protobuf.StructR\x06values\x12\x14\n" +
+	"\x05limit\x18\x05 \x01(\x03R\x05limit\x12\x10\n" +
+	"\x03all\x18\x06 \x01(\bR\x03all\x1aR\n" +
+	"\fFiltersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12,\n" +
+	"\x05value\x18\x02 \x01(\v2\x16.google.protobuf.ValueR\x05value:\x028\x01\"\xed\x01\n" +
+	"\rDeleteRequest\x12\x14\n" +
+	"\x05table\x18\x01 \x01(\tR\x05table\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\x12:\n" +
+	"\afilters\x18\x03 \x03(\v2 .sqld.DeleteRequest.FiltersEntryR\afilters\x12\x14\n" +
+	"\x05limit\x18\x04 \x01(\x03R\x05limit\x12\x10\n" +
+	"\x03all\x18\x05 \x01(\bR\x03all\x1aR\n" +
+	"\fFiltersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12,\n" +
+	"\x05value\x18\x02 \x01(\v2\x16.google.protobuf.ValueR\x05value:\x028\x01\"2\n" +
+	"\vWriteResult\x12#\n" +
+	"\rrows_affected\x18\x01 \x01(\x03R\frowsAffected\"\xd9\x01\n" +
+	"\n" +
+	"RawRequest\x12\x12\n" +
+	"\x04read\x18\x01 \x01(\tR\x04read\x12\x14\n" +
+	"\x05write\x18\x02 \x01(\tR\x05write\x12\x12\n" +
+	"\x04hash\x18\x03 \x01(\tR\x04hash\x12*\n" +
+	"\x04args\x18\x04 \x03(\v2\x16.google.protobuf.ValueR\x04args\x12/\n" +
+	"\x06params\x18\x05 \x01(\v2\x17.google.protobuf.StructR\x06params\x120\n" +
+	"\n" +
+	"statements\x18\x06 \x03(\v2\x10.sqld.RawRequestR\n" +
+	"statements2\x8f\x02\n" +
+	"\x04Sqld\x126\n" +
+	"\x05Query\x12\x12.sqld.QueryRequest\x1a\x17.google.protobuf.Struct0\x01\x126\n" +
+	"\x06Insert\x12\x13.sqld.InsertRequest\x1a\x17.google.protobuf.Struct\x120\n" +
+	"\x06Update\x12\x13.sqld.UpdateRequest\x1a\x11.sqld.WriteResult\x120\n" +
+	"\x06Delete\x12\x13.sqld.DeleteRequest\x1a\x11.sqld.WriteResult\x123\n" +
+	"\aExecRaw\x12\x10.sqld.RawRequest\x1a\x16.google.protobuf.ValueB!Z\x1fgithub.com/mmaelzer/sqld/sqldpbb\x06proto3"
+
+var (
+	file_sqldpb_sqld_proto_rawDescOnce sync.Once
+	file_sqldpb_sqld_proto_rawDescData []byte
+)
+
+func file_sqldpb_sqld_proto_rawDescGZIP() []byte {
+	file_sqldpb_sqld_proto_rawDescOnce.Do(func() {
+		file_sqldpb_sqld_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_sqldpb_sqld_proto_rawDesc), len(file_sqldpb_sqld_proto_rawDesc)))
+	})
+	return file_sqldpb_sqld_proto_rawDescData
+}
+
+var file_sqldpb_sqld_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_sqldpb_sqld_proto_goTypes = []any{
+	(*QueryRequest)(nil),    // 0: sqld.QueryRequest
+	(*InsertRequest)(nil),   // 1: sqld.InsertRequest
+	(*UpdateRequest)(nil),   // 2: sqld.UpdateRequest
+	(*DeleteRequest)(nil),   // 3: sqld.DeleteRequest
+	(*WriteResult)(nil),     // 4: sqld.WriteResult
+	(*RawRequest)(nil),      // 5: sqld.RawRequest
+	nil,                     // 6: sqld.QueryRequest.FiltersEntry
+	nil,                     // 7: sqld.UpdateRequest.FiltersEntry
+	nil,                     // 8: sqld.DeleteRequest.FiltersEntry
+	(*structpb.Struct)(nil), // 9: google.protobuf.Struct
+	(*structpb.Value)(nil),  // 10: google.protobuf.Value
+}
+var file_sqldpb_sqld_proto_depIdxs = []int32{
+	6,  // 0: sqld.QueryRequest.filters:type_name -> sqld.QueryRequest.FiltersEntry
+	9,  // 1: sqld.InsertRequest.row:type_name -> google.protobuf.Struct
+	7,  // 2: sqld.UpdateRequest.filters:type_name -> sqld.UpdateRequest.FiltersEntry
+	9,  // 3: sqld.UpdateRequest.values:type_name -> google.protobuf.Struct
+	8,  // 4: sqld.DeleteRequest.filters:type_name -> sqld.DeleteRequest.FiltersEntry
+	10, // 5: sqld.RawRequest.args:type_name -> google.protobuf.Value
+	9,  // 6: sqld.RawRequest.params:type_name -> google.protobuf.Struct
+	5,  // 7: sqld.RawRequest.statements:type_name -> sqld.RawRequest
+	10, // 8: sqld.QueryRequest.FiltersEntry.value:type_name -> google.protobuf.Value
+	10, // 9: sqld.UpdateRequest.FiltersEntry.value:type_name -> google.protobuf.Value
+	10, // 10: sqld.DeleteRequest.FiltersEntry.value:type_name -> google.protobuf.Value
+	0,  // 11: sqld.Sqld.Query:input_type -> sqld.QueryRequest
+	1,  // 12: sqld.Sqld.Insert:input_type -> sqld.InsertRequest
+	2,  // 13: sqld.Sqld.Update:input_type -> sqld.UpdateRequest
+	3,  // 14: sqld.Sqld.Delete:input_type -> sqld.DeleteRequest
+	5,  // 15: sqld.Sqld.ExecRaw:input_type -> sqld.RawRequest
+	9,  // 16: sqld.Sqld.Query:output_type -> google.protobuf.Struct
+	9,  // 17: sqld.Sqld.Insert:output_type -> google.protobuf.Struct
+	4,  // 18: sqld.Sqld.Update:output_type -> sqld.WriteResult
+	4,  // 19: sqld.Sqld.Delete:output_type -> sqld.WriteResult
+	10, // 20: sqld.Sqld.ExecRaw:output_type -> google.protobuf.Value
+	16, // [16:21] is the sub-list for method output_type
+	11, // [11:16] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
+}
+
+func init() { file_sqldpb_sqld_proto_init() }
+func file_sqldpb_sqld_proto_init() {
+	if File_sqldpb_sqld_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_sqldpb_sqld_proto_rawDesc), len(file_sqldpb_sqld_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_sqldpb_sqld_proto_goTypes,
+		DependencyIndexes: file_sqldpb_sqld_proto_depIdxs,
+		MessageInfos:      file_sqldpb_sqld_proto_msgTypes,
+	}.Build()
+	File_sqldpb_sqld_proto = out.File
+	file_sqldpb_sqld_proto_goTypes = nil
+	file_sqldpb_sqld_proto_depIdxs = nil
+}
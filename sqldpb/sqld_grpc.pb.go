@@ -0,0 +1,302 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: sqldpb/sqld.proto
+
+package sqldpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Sqld_Query_FullMethodName   = "/sqld.Sqld/Query"
+	Sqld_Insert_FullMethodName  = "/sqld.Sqld/Insert"
+	Sqld_Update_FullMethodName  = "/sqld.Sqld/Update"
+	Sqld_Delete_FullMethodName  = "/sqld.Sqld/Delete"
+	Sqld_ExecRaw_FullMethodName = "/sqld.Sqld/ExecRaw"
+)
+
+// SqldClient is the client API for Sqld service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Sqld exposes the tables of the database the same way the HTTP API does.
+// Request metadata is treated like HTTP headers, so x-sqld-transaction,
+// x-sqld-admin-token, if-match and idempotency-key work as they do over
+// HTTP.
+type SqldClient interface {
+	// Query streams the rows of a table matching the request, one message
+	// per row.
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[structpb.Struct], error)
+	// Insert adds a row to a table and returns it with its id.
+	Insert(ctx context.Context, in *InsertRequest, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// Update changes the rows of a table matching the request.
+	Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*WriteResult, error)
+	// Delete removes the rows of a table matching the request.
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*WriteResult, error)
+	// ExecRaw runs a raw SQL query when sqld is started with -raw. The
+	// result has the shape of the HTTP raw query response.
+	ExecRaw(ctx context.Context, in *RawRequest, opts ...grpc.CallOption) (*structpb.Value, error)
+}
+
+type sqldClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSqldClient(cc grpc.ClientConnInterface) SqldClient {
+	return &sqldClient{cc}
+}
+
+func (c *sqldClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[structpb.Struct], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Sqld_ServiceDesc.Streams[0], Sqld_Query_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[QueryRequest, structpb.Struct]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Sqld_QueryClient = grpc.ServerStreamingClient[structpb.Struct]
+
+func (c *sqldClient) Insert(ctx context.Context, in *InsertRequest, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(structpb.Struct)
+	err := c.cc.Invoke(ctx, Sqld_Insert_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sqldClient) Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*WriteResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WriteResult)
+	err := c.cc.Invoke(ctx, Sqld_Update_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sqldClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*WriteResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WriteResult)
+	err := c.cc.Invoke(ctx, Sqld_Delete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sqldClient) ExecRaw(ctx context.Context, in *RawRequest, opts ...grpc.CallOption) (*structpb.Value, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(structpb.Value)
+	err := c.cc.Invoke(ctx, Sqld_ExecRaw_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SqldServer is the server API for Sqld service.
+// All implementations must embed UnimplementedSqldServer
+// for forward compatibility.
+//
+// Sqld exposes the tables of the database the same way the HTTP API does.
+// Request metadata is treated like HTTP headers, so x-sqld-transaction,
+// x-sqld-admin-token, if-match and idempotency-key work as they do over
+// HTTP.
+type SqldServer interface {
+	// Query streams the rows of a table matching the request, one message
+	// per row.
+	Query(*QueryRequest, grpc.ServerStreamingServer[structpb.Struct]) error
+	// Insert adds a row to a table and returns it with its id.
+	Insert(context.Context, *InsertRequest) (*structpb.Struct, error)
+	// Update changes the rows of a table matching the request.
+	Update(context.Context, *UpdateRequest) (*WriteResult, error)
+	// Delete removes the rows of a table matching the request.
+	Delete(context.Context, *DeleteRequest) (*WriteResult, error)
+	// ExecRaw runs a raw SQL query when sqld is started with -raw. The
+	// result has the shape of the HTTP raw query response.
+	ExecRaw(context.Context, *RawRequest) (*structpb.Value, error)
+	mustEmbedUnimplementedSqldServer()
+}
+
+// UnimplementedSqldServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSqldServer struct{}
+
+func (UnimplementedSqldServer) Query(*QueryRequest, grpc.ServerStreamingServer[structpb.Struct]) error {
+	return status.Errorf(codes.Unimplemented, "method Query not implemented")
+}
+func (UnimplementedSqldServer) Insert(context.Context, *InsertRequest) (*structpb.Struct, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Insert not implemented")
+}
+func (UnimplementedSqldServer) Update(context.Context, *UpdateRequest) (*WriteResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
+}
+func (UnimplementedSqldServer) Delete(context.Context, *DeleteRequest) (*WriteResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedSqldServer) ExecRaw(context.Context, *RawRequest) (*structpb.Value, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExecRaw not implemented")
+}
+func (UnimplementedSqldServer) mustEmbedUnimplementedSqldServer() {}
+func (UnimplementedSqldServer) testEmbeddedByValue()              {}
+
+// UnsafeSqldServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SqldServer will
+// result in compilation errors.
+type UnsafeSqldServer interface {
+	mustEmbedUnimplementedSqldServer()
+}
+
+func RegisterSqldServer(s grpc.ServiceRegistrar, srv SqldServer) {
+	// If the following call pancis, it indicates UnimplementedSqldServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Sqld_ServiceDesc, srv)
+}
+
+func _Sqld_Query_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(QueryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SqldServer).Query(m, &grpc.GenericServerStream[QueryRequest, structpb.Struct]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Sqld_QueryServer = grpc.ServerStreamingServer[structpb.Struct]
+
+func _Sqld_Insert_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InsertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SqldServer).Insert(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sqld_Insert_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SqldServer).Insert(ctx, req.(*InsertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sqld_Update_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SqldServer).Update(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sqld_Update_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SqldServer).Update(ctx, req.(*UpdateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sqld_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SqldServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sqld_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SqldServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sqld_ExecRaw_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RawRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SqldServer).ExecRaw(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sqld_ExecRaw_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SqldServer).ExecRaw(ctx, req.(*RawRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Sqld_ServiceDesc is the grpc.ServiceDesc for Sqld service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Sqld_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sqld.Sqld",
+	HandlerType: (*SqldServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Insert",
+			Handler:    _Sqld_Insert_Handler,
+		},
+		{
+			MethodName: "Update",
+			Handler:    _Sqld_Update_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _Sqld_Delete_Handler,
+		},
+		{
+			MethodName: "ExecRaw",
+			Handler:    _Sqld_ExecRaw_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Query",
+			Handler:       _Sqld_Query_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "sqldpb/sqld.proto",
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// txRoute is the url path, relative to the url prefix, that serves
+	// transaction requests
+	txRoute = "_tx"
+
+	// txHeader is the request header that runs a request inside an open
+	// transaction
+	txHeader = "X-Sqld-Transaction"
+
+	// savepointName names the savepoint requests inside an open
+	// transaction run in. Requests using a transaction are serialized, so
+	// one name is enough.
+	savepointName = "sqld_request"
+
+	// importSavepoint names the savepoint each batch of an import is
+	// inserted in. MySQL replaces a savepoint when the name is reused, so
+	// it must differ from savepointName.
+	importSavepoint = "sqld_import"
+)
+
+var (
+	txMu         sync.Mutex
+	transactions = make(map[string]*transaction)
+)
+
+// transaction is a database transaction that spans multiple http requests.
+// Requests using the same transaction are serialized and the transaction is
+// rolled back once it has been idle for longer than the tx-timeout flag.
+type transaction struct {
+	sync.Mutex
+	id      string
+	tx      *sqlx.Tx
+	timeout time.Duration
+	timer   *time.Timer
+	done    bool
+
+	// readOnly is set for transactions the database refuses to write in
+	readOnly bool
+}
+
+// release marks the end of a request using the transaction and restarts
+// its idle timer.
+func (t *transaction) release() {
+	t.timer.Reset(t.timeout)
+	t.Unlock()
+}
+
+// finish commits or rolls back the transaction and removes it from the
+// set of open transactions.
+func (t *transaction) finish(commit bool) error {
+	txMu.Lock()
+	delete(transactions, t.id)
+	txMu.Unlock()
+
+	t.timer.Stop()
+	t.done = true
+	if t.readOnly {
+		endReadOnly(t.tx)
+	}
+	if commit {
+		err := t.tx.Commit()
+		finishChanges(t.tx, err == nil)
+		return err
+	}
+	finishChanges(t.tx, false)
+	return t.tx.Rollback()
+}
+
+func newTxID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// beginTx starts a new transaction and returns its id. A readOnly
+// transaction refuses writes and may be joined by raw reads.
+func beginTx(readOnly bool) (string, error) {
+	id, err := newTxID()
+	if err != nil {
+		return "", err
+	}
+
+	var tx *sqlx.Tx
+	if readOnly {
+		var sqldErr *SqldError
+		if tx, _, sqldErr = beginReadOnly(); sqldErr != nil {
+			return "", sqldErr
+		}
+	} else if tx, err = db.Beginx(); err != nil {
+		return "", err
+	}
+
+	t := &transaction{id: id, tx: tx, timeout: *txTimeout, readOnly: readOnly}
+	t.timer = time.AfterFunc(t.timeout, func() { expireTx(t) })
+
+	txMu.Lock()
+	transactions[id] = t
+	txMu.Unlock()
+	return id, nil
+}
+
+// expireTx rolls back a transaction that has been idle for too long.
+func expireTx(t *transaction) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.done {
+		return
+	}
+	if err := t.finish(false); err != nil {
+		log.Printf("transaction %s expired, rollback failed: %s", t.id, err)
+		return
+	}
+	log.Printf("transaction %s rolled back after %s idle", t.id, t.timeout)
+}
+
+// acquireTx locks the transaction with the given id for the duration of a
+// request. The caller must call release (or finish) on the transaction.
+func acquireTx(id string) (*transaction, *SqldError) {
+	txMu.Lock()
+	t, ok := transactions[id]
+	txMu.Unlock()
+	if !ok {
+		return nil, NotFound(errors.New("unknown transaction " + id))
+	}
+
+	t.Lock()
+	if t.done || !t.timer.Stop() {
+		// The idle timer fired while we waited on the lock
+		t.Unlock()
+		return nil, NotFound(errors.New("unknown transaction " + id))
+	}
+	return t, nil
+}
+
+// txReadOnly reports whether the open transaction with the given id was
+// started read-only. found is false when there is no such transaction.
+func txReadOnly(id string) (readOnly, found bool) {
+	txMu.Lock()
+	defer txMu.Unlock()
+
+	t, ok := transactions[id]
+	if !ok {
+		return false, false
+	}
+	return t.readOnly, true
+}
+
+// executor returns the database handle a request should run against.
+// Requests carrying the transaction header run inside that transaction.
+// release must be called once the request is done with the handle.
+func executor(r *http.Request) (sqlx.Ext, func(), *SqldError) {
+	id := r.Header.Get(txHeader)
+	if id == "" {
+		return db, func() {}, nil
+	}
+
+	t, err := acquireTx(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t.tx, t.release, nil
+}
+
+// withTx runs fn inside a transaction, committing when fn succeeds and
+// rolling back when it fails. Requests carrying the transaction header run
+// fn inside a savepoint of that transaction, rolled back to when fn fails,
+// and leave finishing the transaction to the client.
+func withTx(r *http.Request, fn func(sqlx.Ext) (interface{}, *SqldError)) (interface{}, *SqldError) {
+	if r.Header.Get(txHeader) != "" {
+		ext, release, sqldErr := executor(r)
+		if sqldErr != nil {
+			return nil, sqldErr
+		}
+		defer release()
+		return withSavepoint(ext, savepointName, fn)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, InternalError(err)
+	}
+
+	data, sqldErr := fn(tx)
+	if sqldErr != nil {
+		finishChanges(tx, false)
+		tx.Rollback()
+		return nil, sqldErr
+	}
+	err = tx.Commit()
+	finishChanges(tx, err == nil)
+	if err != nil {
+		return nil, InternalError(err)
+	}
+	return data, nil
+}
+
+// withSavepoint runs fn inside the named savepoint of an open
+// transaction, so a request that fails leaves none of its changes behind
+// for the client to commit.
+func withSavepoint(ext sqlx.Ext, name string, fn func(sqlx.Ext) (interface{}, *SqldError)) (interface{}, *SqldError) {
+	if _, err := ext.Exec("SAVEPOINT " + name); err != nil {
+		return nil, InternalError(err)
+	}
+	mark := changeMark(ext)
+
+	data, sqldErr := fn(ext)
+	if sqldErr != nil {
+		if _, err := ext.Exec("ROLLBACK TO SAVEPOINT " + name); err != nil {
+			return nil, InternalError(err)
+		}
+		discardChanges(ext, mark)
+	}
+	if _, err := ext.Exec("RELEASE SAVEPOINT " + name); err != nil && sqldErr == nil {
+		return nil, InternalError(err)
+	}
+	return data, sqldErr
+}
+
+// beginReadOnly begins a transaction the database refuses to write in.
+// The returned release func rolls it back.
+func beginReadOnly() (*sqlx.Tx, func(), *SqldError) {
+	// Postgres and MySQL start the transaction with BEGIN READ ONLY and
+	// START TRANSACTION READ ONLY, the SQLite driver ignores the option
+	tx, err := db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, nil, InternalError(err)
+	}
+
+	if *dbtype != "sqlite3" {
+		return tx, func() { tx.Rollback() }, nil
+	}
+
+	// query_only belongs to the connection, so it must be switched back
+	// off before the connection returns to the pool
+	if _, err := tx.Exec("PRAGMA query_only = ON"); err != nil {
+		tx.Rollback()
+		return nil, nil, InternalError(err)
+	}
+	return tx, func() {
+		endReadOnly(tx)
+		tx.Rollback()
+	}, nil
+}
+
+// endReadOnly lets the connection of a transaction begun by beginReadOnly
+// write again once the transaction ends.
+func endReadOnly(tx *sqlx.Tx) {
+	if *dbtype == "sqlite3" {
+		tx.Exec("PRAGMA query_only = OFF")
+	}
+}
+
+// withReadOnlyTx runs fn inside a transaction the database refuses to
+// write in. The transaction is always rolled back.
+func withReadOnlyTx(fn func(sqlx.Ext) (interface{}, *SqldError)) (interface{}, *SqldError) {
+	tx, release, sqldErr := beginReadOnly()
+	if sqldErr != nil {
+		return nil, sqldErr
+	}
+	defer release()
+
+	return fn(tx)
+}
+
+// handleTx handles requests to the transaction route:
+//
+//	POST {url}_tx               begins a transaction and returns its id,
+//	                            ?read_only=true begins a read-only one
+//	POST {url}_tx/:id/commit    commits the transaction
+//	POST {url}_tx/:id/rollback  rolls back the transaction
+func handleTx(r *http.Request) (interface{}, *SqldError) {
+	if r.Method != "POST" {
+		return nil, NewError(nil, http.StatusMethodNotAllowed)
+	}
+
+	paths := routePaths(r)
+	switch len(paths) {
+	case 1:
+		id, err := beginTx(r.URL.Query().Get("read_only") == "true")
+		if err != nil {
+			return nil, InternalError(err)
+		}
+		return map[string]interface{}{"id": id}, nil
+	case 3:
+		var commit bool
+		switch paths[2] {
+		case "commit":
+			commit = true
+		case "rollback":
+			commit = false
+		default:
+			return nil, NotFound(nil)
+		}
+
+		t, sqldErr := acquireTx(paths[1])
+		if sqldErr != nil {
+			return nil, sqldErr
+		}
+		defer t.Unlock()
+
+		if err := t.finish(commit); err != nil {
+			return nil, InternalError(err)
+		}
+		return nil, nil
+	}
+	return nil, NotFound(nil)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func beginTestTx(t *testing.T, handler http.Handler) string {
+	req, _ := http.NewRequest("POST", "http://example.com/_tx", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, http.StatusCreated)
+
+	var body map[string]string
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return body["id"]
+}
+
+func TestHandleTx(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	handler := http.HandlerFunc(handleQuery)
+
+	createDB()
+	defer closeDB()
+
+	id := beginTestTx(t, handler)
+	assert.Len(id, 32)
+
+	b := bytes.NewBufferString(`{"a": "in", "b": "tx"}`)
+	req, _ := http.NewRequest("POST", "http://example.com/t1", b)
+	req.Header.Set(txHeader, id)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusCreated)
+
+	req, _ = http.NewRequest("GET", "http://example.com/t1?a=in", nil)
+	req.Header.Set(txHeader, id)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	data := []TestData{}
+	json.Unmarshal(w.Body.Bytes(), &data)
+	assert.Equal(len(data), 1)
+
+	req, _ = http.NewRequest("POST", "http://example.com/_tx/"+id+"/rollback", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusNoContent)
+
+	req, _ = http.NewRequest("GET", "http://example.com/t1?a=in", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	data = []TestData{}
+	json.Unmarshal(w.Body.Bytes(), &data)
+	assert.Equal(len(data), 0)
+
+	id = beginTestTx(t, handler)
+	b = bytes.NewBufferString(`{"a": "in", "b": "tx"}`)
+	req, _ = http.NewRequest("POST", "http://example.com/t1", b)
+	req.Header.Set(txHeader, id)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusCreated)
+
+	req, _ = http.NewRequest("POST", "http://example.com/_tx/"+id+"/commit", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusNoContent)
+
+	req, _ = http.NewRequest("GET", "http://example.com/t1?a=in", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	data = []TestData{}
+	json.Unmarshal(w.Body.Bytes(), &data)
+	assert.Equal(len(data), 1)
+
+	req, _ = http.NewRequest("POST", "http://example.com/_tx/"+id+"/commit", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusNotFound)
+
+	req, _ = http.NewRequest("GET", "http://example.com/t1", nil)
+	req.Header.Set(txHeader, "nope")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusNotFound)
+
+	req, _ = http.NewRequest("GET", "http://example.com/_tx", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusMethodNotAllowed)
+}
+
+func TestExpireTx(t *testing.T) {
+	assert := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+
+	createDB()
+	defer closeDB()
+
+	timeout := *txTimeout
+	*txTimeout = 10 * time.Millisecond
+	defer func() { *txTimeout = timeout }()
+
+	id, err := beginTx(false)
+	assert.Nil(err)
+
+	time.Sleep(50 * time.Millisecond)
+	_, sqldErr := acquireTx(id)
+	assert.Equal(sqldErr.Code, http.StatusNotFound)
+}
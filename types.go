@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// columnKinds groups database type names by how their values are encoded
+// in responses. Drivers that send values as text, like MySQL outside of
+// prepared statements, report them as []byte whatever the column type.
+var columnKinds = map[string]string{
+	"INT": "int", "INTEGER": "int", "TINYINT": "int", "SMALLINT": "int",
+	"MEDIUMINT": "int", "BIGINT": "int", "INT2": "int", "INT4": "int",
+	"INT8": "int", "SERIAL": "int", "BIGSERIAL": "int", "YEAR": "int",
+	"UNSIGNED INT": "int", "UNSIGNED TINYINT": "int", "UNSIGNED SMALLINT": "int",
+	"UNSIGNED MEDIUMINT": "int", "UNSIGNED BIGINT": "int",
+
+	"FLOAT": "float", "DOUBLE": "float", "REAL": "float", "FLOAT4": "float",
+	"FLOAT8": "float", "DOUBLE PRECISION": "float",
+
+	"DECIMAL": "decimal", "NUMERIC": "decimal", "MONEY": "decimal",
+
+	"BLOB": "binary", "TINYBLOB": "binary", "MEDIUMBLOB": "binary",
+	"LONGBLOB": "binary", "BINARY": "binary", "VARBINARY": "binary",
+	"BYTEA": "binary", "BIT": "binary", "GEOMETRY": "binary",
+
+	"BOOL": "bool", "BOOLEAN": "bool",
+
+	"DATETIME": "time", "TIMESTAMP": "time", "TIMESTAMPTZ": "time",
+}
+
+// mysqlTimeLayout is the layout MySQL sends DATETIME and TIMESTAMP values
+// in when the connection does not parse them.
+const mysqlTimeLayout = "2006-01-02 15:04:05.999999999"
+
+// columnKind returns how values of a column are encoded.
+func columnKind(ct *sql.ColumnType) string {
+	name := strings.ToUpper(ct.DatabaseTypeName())
+	if i := strings.IndexByte(name, '('); i >= 0 {
+		name = strings.TrimSpace(name[:i])
+	}
+	return columnKinds[name]
+}
+
+// columnValue converts a scanned value into the value sent to clients, so
+// results look the same whichever driver produced them. Integers and
+// floats are numbers, decimals are strings holding the exact value, binary
+// data is kept as bytes (base64 in JSON), timestamps are RFC 3339 strings,
+// booleans are booleans, and any other text is a string.
+func columnValue(kind string, v interface{}) interface{} {
+	switch val := v.(type) {
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	case []byte:
+		s := string(val)
+		switch kind {
+		case "binary":
+			return val
+		case "int":
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return i
+			}
+			if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+				return u
+			}
+		case "float":
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return f
+			}
+		case "bool":
+			if b, err := strconv.ParseBool(s); err == nil {
+				return b
+			}
+		case "time":
+			if t, err := time.Parse(mysqlTimeLayout, s); err == nil {
+				return t.Format(time.RFC3339Nano)
+			}
+		}
+		return s
+	case float64:
+		if kind == "decimal" {
+			return strconv.FormatFloat(val, 'f', -1, 64)
+		}
+	case int64:
+		if kind == "decimal" {
+			return strconv.FormatInt(val, 10)
+		}
+	}
+	return v
+}
+
+// columnKindsOf returns the kinds of a result's columns.
+func columnKindsOf(columns []*sql.ColumnType) []string {
+	kinds := make([]string, len(columns))
+	for i, ct := range columns {
+		kinds[i] = columnKind(ct)
+	}
+	return kinds
+}
+
+// toInt64 converts a value from columnValue for an integer column.
+func toInt64(v interface{}) (int64, error) {
+	switch val := v.(type) {
+	case int64:
+		return val, nil
+	case uint64:
+		return int64(val), nil
+	case float64:
+		return int64(val), nil
+	case bool:
+		if val {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return strconv.ParseInt(fmt.Sprint(v), 10, 64)
+}
+
+// toFloat64 converts a value from columnValue for a float column.
+func toFloat64(v interface{}) (float64, error) {
+	switch val := v.(type) {
+	case float64:
+		return val, nil
+	case float32:
+		return float64(val), nil
+	case int64:
+		return float64(val), nil
+	}
+	return strconv.ParseFloat(fmt.Sprint(v), 64)
+}
+
+// toTime converts a value from columnValue for a timestamp column.
+func toTime(v interface{}) (time.Time, error) {
+	if t, ok := v.(time.Time); ok {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339Nano, fmt.Sprint(v))
+}
+
+// toBool converts a value from columnValue for a boolean column.
+func toBool(v interface{}) (bool, error) {
+	switch val := v.(type) {
+	case bool:
+		return val, nil
+	case int64:
+		return val != 0, nil
+	}
+	return strconv.ParseBool(fmt.Sprint(v))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColumnValue(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(columnValue("int", []byte("42")), int64(42))
+	assert.Equal(columnValue("int", []byte("18446744073709551615")), uint64(18446744073709551615))
+	assert.Equal(columnValue("float", []byte("1.5")), 1.5)
+	assert.Equal(columnValue("decimal", []byte("12345678901234567890.01")), "12345678901234567890.01")
+	assert.Equal(columnValue("decimal", 2.5), "2.5")
+	assert.Equal(columnValue("decimal", int64(3)), "3")
+	assert.Equal(columnValue("binary", []byte{0, 255}), []byte{0, 255})
+	assert.Equal(columnValue("time", []byte("2020-01-02 03:04:05")), "2020-01-02T03:04:05Z")
+	assert.Equal(columnValue("", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)), "2020-01-02T03:04:05Z")
+	assert.Equal(columnValue("", []byte("text")), "text")
+	assert.Equal(columnValue("int", nil), nil)
+}
+
+func TestReadTypes(t *testing.T) {
+	assert := assert.New(t)
+
+	createDB()
+	defer closeDB()
+	db.MustExec("CREATE TABLE typed(i INTEGER, r REAL, d DECIMAL(10,2), b BLOB, t DATETIME, s TEXT)")
+	db.MustExec("INSERT INTO typed VALUES (7, 1.25, 10.5, x'00ff', '2020-01-02 03:04:05', 'hi')")
+
+	data, err := readQuery(db, "SELECT * FROM typed", nil)
+	assert.Nil(err)
+	b, _ := json.Marshal(data[0])
+	assert.JSONEq(string(b), `{
+		"i": 7,
+		"r": 1.25,
+		"d": "10.5",
+		"b": "AP8=",
+		"t": "2020-01-02T03:04:05Z",
+		"s": "hi"
+	}`)
+}